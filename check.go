@@ -0,0 +1,367 @@
+package main
+
+// sdget check: asserts that a source has the expected records, as a monitoring plugin (Nagios, Icinga, Sensu, etc.)
+//
+// The output is one status line plus performance data, and the exit code is the standard plugin status:
+//
+//	SDGET CRITICAL - mirror is "b.example.com", expected "a.example.com" | time=0.012345s;;;0 records=5;;;0 ttl=300s;;;0
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+type checkStatus int
+
+// Exit codes of monitoring plugins
+const (
+	checkOK checkStatus = iota
+	checkWarning
+	checkCritical
+	checkUnknown
+)
+
+var checkStatusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+func (s checkStatus) String() string {
+	return checkStatusNames[s]
+}
+
+// Thresholds as given on the command line, so that bad ones can be reported as UNKNOWN instead of a usage error
+type checkOptions struct {
+	ttlWarning        string
+	ttlCritical       string
+	signatureWarning  string
+	signatureCritical string
+}
+
+// An expected record: key=value, key~=regex or key? (present)
+type expectation struct {
+	key      string
+	operator string
+	value    string
+	pattern  *regexp.Regexp
+}
+
+func parseExpectation(arg string) (expectation, error) {
+	if strings.HasSuffix(arg, "?") && !strings.Contains(arg, "=") {
+		if key := arg[:len(arg)-1]; key != "" {
+			return expectation{key: key, operator: "?"}, nil
+		}
+	}
+	i := strings.Index(arg, "=")
+	if i <= 0 {
+		return expectation{}, errors.Errorf("invalid expectation \"%s\" (expected key=value, key~=regex or key?)", arg)
+	}
+	if arg[i-1] != '~' {
+		return expectation{key: arg[:i], operator: "=", value: arg[i+1:]}, nil
+	}
+	if i == 1 {
+		return expectation{}, errors.Errorf("invalid expectation \"%s\": no key", arg)
+	}
+	pattern, err := regexp.Compile(arg[i+1:])
+	if err != nil {
+		return expectation{}, errors.Wrapf(err, "invalid regex in expectation \"%s\"", arg)
+	}
+	return expectation{key: arg[:i-1], operator: "~=", value: arg[i+1:], pattern: pattern}, nil
+}
+
+func (e expectation) String() string {
+	return e.key + e.operator + e.value
+}
+
+// What's wrong with the key's values, or "" if they're as expected
+func (e expectation) problem(values []string) string {
+	if len(values) == 0 {
+		return fmt.Sprintf("%s missing", e.key)
+	}
+	for _, value := range values {
+		if e.operator == "?" || e.operator == "=" && value == e.value || e.operator == "~=" && e.pattern.MatchString(value) {
+			return ""
+		}
+	}
+	got := fmt.Sprintf("%q", values[0])
+	if len(values) > 1 {
+		got = fmt.Sprintf("%q", values)
+	}
+	if e.operator == "~=" {
+		return fmt.Sprintf("%s is %s, expected a match of %q", e.key, got, e.value)
+	}
+	return fmt.Sprintf("%s is %s, expected %q", e.key, got, e.value)
+}
+
+// A threshold in the monitoring plugin range format: N (0 to N), N: (at least N), ~:N (at most N), N:M, or any of
+// these starting with @ to alert inside the range instead of outside it
+type thresholdRange struct {
+	text   string
+	start  float64
+	end    float64
+	inside bool
+}
+
+func parseThresholdRange(text string) (*thresholdRange, error) {
+	if text == "" {
+		return nil, nil
+	}
+	result := &thresholdRange{text: text, end: math.Inf(1)}
+	spec := text
+	if strings.HasPrefix(spec, "@") {
+		result.inside = true
+		spec = spec[1:]
+	}
+	start, end := "0", spec
+	if i := strings.Index(spec, ":"); i >= 0 {
+		start, end = spec[:i], spec[i+1:]
+	}
+	var err error
+	switch start {
+	case "~":
+		result.start = math.Inf(-1)
+	case "":
+		return nil, errors.Errorf("invalid range \"%s\": no start", text)
+	default:
+		if result.start, err = strconv.ParseFloat(start, 64); err != nil {
+			return nil, errors.Errorf("invalid range \"%s\": bad start %s", text, start)
+		}
+	}
+	if end != "" {
+		if result.end, err = strconv.ParseFloat(end, 64); err != nil {
+			return nil, errors.Errorf("invalid range \"%s\": bad end %s", text, end)
+		}
+	}
+	if result.start > result.end {
+		return nil, errors.Errorf("invalid range \"%s\": start is after end", text)
+	}
+	return result, nil
+}
+
+func (r *thresholdRange) alerts(value float64) bool {
+	if r == nil {
+		return false
+	}
+	inRange := value >= r.start && value <= r.end
+	return inRange == r.inside
+}
+
+// For performance data, where no threshold is an empty field
+func (r *thresholdRange) String() string {
+	if r == nil {
+		return ""
+	}
+	return r.text
+}
+
+// Signatures alert when they expire in less than the duration, i.e., outside the range from that many seconds up
+func parseSignatureThreshold(text string) (*thresholdRange, error) {
+	if text == "" {
+		return nil, nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return nil, errors.Errorf("invalid signature threshold \"%s\" (expected a duration, e.g., 168h)", text)
+	}
+	return parseThresholdRange(strconv.FormatInt(int64(duration.Seconds()), 10) + ":")
+}
+
+type checkThresholds struct {
+	ttlWarning        *thresholdRange
+	ttlCritical       *thresholdRange
+	signatureWarning  *thresholdRange
+	signatureCritical *thresholdRange
+}
+
+func makeCheckThresholds(checkOptions *checkOptions) (*checkThresholds, error) {
+	thresholds := &checkThresholds{}
+	var err error
+	if thresholds.ttlWarning, err = parseThresholdRange(checkOptions.ttlWarning); err != nil {
+		return nil, errors.Wrap(err, "invalid --ttl-warning")
+	}
+	if thresholds.ttlCritical, err = parseThresholdRange(checkOptions.ttlCritical); err != nil {
+		return nil, errors.Wrap(err, "invalid --ttl-critical")
+	}
+	if thresholds.signatureWarning, err = parseSignatureThreshold(checkOptions.signatureWarning); err != nil {
+		return nil, errors.Wrap(err, "invalid --signature-warning")
+	}
+	if thresholds.signatureCritical, err = parseSignatureThreshold(checkOptions.signatureCritical); err != nil {
+		return nil, errors.Wrap(err, "invalid --signature-critical")
+	}
+	return thresholds, nil
+}
+
+func (t *checkThresholds) checkSignatures() bool {
+	return t.signatureWarning != nil || t.signatureCritical != nil
+}
+
+// The TTLs and signatures of the answers to the lookup's queries
+type answerStats struct {
+	answers    int
+	ttl        uint32    // Lowest TTL of the answers
+	expiration time.Time // Earliest expiration of the answers' signatures
+	unsigned   int       // Answers without a signature
+}
+
+func (s *answerStats) observe(qtype uint16) func(response *dns.Msg) {
+	return func(response *dns.Msg) {
+		found, signed := false, false
+		for _, rr := range response.Answer {
+			header := rr.Header()
+			if header.Rrtype == qtype {
+				if s.answers == 0 && !found || header.Ttl < s.ttl {
+					s.ttl = header.Ttl
+				}
+				found = true
+			}
+			if signature, ok := rr.(*dns.RRSIG); ok && signature.TypeCovered == qtype {
+				expiration := time.Unix(int64(signature.Expiration), 0).UTC()
+				if s.expiration.IsZero() || expiration.Before(s.expiration) {
+					s.expiration = expiration
+				}
+				signed = true
+			}
+		}
+		if !found {
+			return
+		}
+		s.answers++
+		if !signed {
+			s.unsigned++
+		}
+	}
+}
+
+// Sets the DO bit, so that signed zones' answers include their RRSIGs
+func requestSignatures(options *options, query *dns.Msg) {
+	if !options.dnssecOK {
+		return
+	}
+	if opt := query.IsEdns0(); opt != nil {
+		opt.SetDo()
+		return
+	}
+	query.SetEdns0(dns.DefaultMsgSize, true)
+}
+
+type checkResult struct {
+	status   checkStatus
+	problems []string
+	summary  string // For OK
+	perfdata []string
+}
+
+func (r *checkResult) raise(status checkStatus, problem string) {
+	if status > r.status {
+		r.status = status
+	}
+	r.problems = append(r.problems, problem)
+}
+
+// The plugin output line
+func (r *checkResult) String() string {
+	text := r.summary
+	if len(r.problems) > 0 {
+		text = strings.Join(r.problems, "; ")
+	}
+	line := fmt.Sprintf("SDGET %s - %s", r.status, text)
+	if len(r.perfdata) > 0 {
+		line += " | " + strings.Join(r.perfdata, " ")
+	}
+	return line
+}
+
+// Looks up the keys of the expectations in source and checks them, and the answers' TTLs and signatures
+func runCheck(options *options, thresholds *checkThresholds, source string, expectations []expectation) *checkResult {
+	result := &checkResult{}
+	if options.resolver == "system" && (thresholds.checkSignatures() || thresholds.ttlWarning != nil || thresholds.ttlCritical != nil) {
+		result.raise(checkUnknown, "TTL and signature thresholds aren't available with --resolver system")
+		return result
+	}
+	stats := &answerStats{}
+	listOptions := *options
+	listOptions.valueType = "list"
+	listOptions.dnssecOK = thresholds.checkSignatures()
+	listOptions.fileSource = isFileSource(options, source)
+
+	start := time.Now()
+	provider, err := getTxtProvider(&listOptions, source)
+	if err != nil {
+		result.raise(checkUnknown, err.Error())
+		return result
+	}
+	qtype := dns.TypeTXT
+	if dnsProvider, ok := provider.(*dnsProvider); ok {
+		qtype = dnsProvider.qtype
+	}
+	listOptions.onResponse = stats.observe(qtype)
+	setUpInterpolation(&listOptions, source)
+
+	fetcher := &keyRecordsFetcher{options: &listOptions, provider: provider}
+	var txtRecords []string
+	for _, expectation := range expectations {
+		if txtRecords, err = fetcher.recordsFor(expectation.key); err != nil {
+			result.raise(checkCritical, fmt.Sprintf("error looking up %s: %s", expectation.key, err.Error()))
+			continue
+		}
+		values, err := lookUpValues(&listOptions, txtRecords, expectation.key, nil)
+		if err != nil {
+			result.raise(checkCritical, fmt.Sprintf("error looking up %s: %s", expectation.key, err.Error()))
+			continue
+		}
+		if problem := expectation.problem(values); problem != "" {
+			result.raise(checkCritical, problem)
+		}
+	}
+	records := fetcher.total
+	if len(expectations) == 0 {
+		if txtRecords, err = provider.getTxtRecords(); err != nil {
+			result.raise(checkCritical, fmt.Sprintf("error looking up %s: %s", source, err.Error()))
+		}
+		records = len(txtRecords)
+	}
+	elapsed := time.Since(start)
+
+	result.perfdata = append(result.perfdata, fmt.Sprintf("time=%.6fs;;;0", elapsed.Seconds()), fmt.Sprintf("records=%d;;;0", records))
+	if stats.answers > 0 {
+		checkTTL(result, thresholds, stats.ttl)
+		if thresholds.checkSignatures() {
+			checkSignatureExpiry(result, thresholds, stats, time.Now())
+		}
+	}
+	result.summary = fmt.Sprintf("%d expectation(s) met in %s", len(expectations), source)
+	return result
+}
+
+func checkTTL(result *checkResult, thresholds *checkThresholds, ttl uint32) {
+	switch {
+	case thresholds.ttlCritical.alerts(float64(ttl)):
+		result.raise(checkCritical, fmt.Sprintf("TTL %ds outside %s", ttl, thresholds.ttlCritical))
+	case thresholds.ttlWarning.alerts(float64(ttl)):
+		result.raise(checkWarning, fmt.Sprintf("TTL %ds outside %s", ttl, thresholds.ttlWarning))
+	}
+	result.perfdata = append(result.perfdata, fmt.Sprintf("ttl=%ds;%s;%s;0", ttl, thresholds.ttlWarning, thresholds.ttlCritical))
+}
+
+func checkSignatureExpiry(result *checkResult, thresholds *checkThresholds, stats *answerStats, now time.Time) {
+	if stats.unsigned > 0 {
+		result.raise(checkCritical, fmt.Sprintf("%d of %d answer(s) not signed", stats.unsigned, stats.answers))
+	}
+	if stats.expiration.IsZero() {
+		return
+	}
+	remaining := int64(stats.expiration.Sub(now).Seconds())
+	switch {
+	case remaining <= 0:
+		result.raise(checkCritical, fmt.Sprintf("signature expired at %s", stats.expiration.Format(time.RFC3339)))
+	case thresholds.signatureCritical.alerts(float64(remaining)):
+		result.raise(checkCritical, fmt.Sprintf("signature expires at %s", stats.expiration.Format(time.RFC3339)))
+	case thresholds.signatureWarning.alerts(float64(remaining)):
+		result.raise(checkWarning, fmt.Sprintf("signature expires at %s", stats.expiration.Format(time.RFC3339)))
+	}
+	result.perfdata = append(result.perfdata, fmt.Sprintf("signature_expiry=%ds;%s;%s", remaining, thresholds.signatureWarning, thresholds.signatureCritical))
+}
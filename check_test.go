@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type parseExpectationTestPair struct {
+	Input    string
+	Key      string
+	Operator string
+	Value    string
+	Err      error
+}
+
+func TestParseExpectation(t *testing.T) {
+	for _, testPair := range []parseExpectationTestPair{
+		{"region=eu", "region", "=", "eu", nil},
+		{"url=https://example.com/?a=b", "url", "=", "https://example.com/?a=b", nil},
+		{"region=", "region", "=", "", nil},
+		{"port~=^[0-9]+$", "port", "~=", "^[0-9]+$", nil},
+		{"region?", "region", "?", "", nil},
+		{"region?=eu", "region?", "=", "eu", nil},
+		{"region", "", "", "", errors.New("No operator")},
+		{"?", "", "", "", errors.New("No key")},
+		{"=eu", "", "", "", errors.New("No key")},
+		{"~=eu", "", "", "", errors.New("No key")},
+		{"port~=[0-9", "", "", "", errors.New("Bad regex")},
+	} {
+		result, err := parseExpectation(testPair.Input)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err == nil && (result.key != testPair.Key || result.operator != testPair.Operator || result.value != testPair.Value) {
+			t.Error("Expected", testPair.Key, testPair.Operator, testPair.Value, "but got", result, "for", testPair)
+		}
+	}
+}
+
+type expectationProblemTestPair struct {
+	Expectation string
+	Values      []string
+	Result      string
+}
+
+func TestExpectationProblem(t *testing.T) {
+	for _, testPair := range []expectationProblemTestPair{
+		{"region=eu", []string{"eu"}, ""},
+		{"region=eu", []string{"us", "eu"}, ""},
+		{"region=eu", []string{"us"}, "region is \"us\", expected \"eu\""},
+		{"region=eu", []string{"us", "ap"}, "region is [\"us\" \"ap\"], expected \"eu\""},
+		{"region=eu", nil, "region missing"},
+		{"port~=^[0-9]+$", []string{"8080"}, ""},
+		{"port~=^[0-9]+$", []string{"http"}, "port is \"http\", expected a match of \"^[0-9]+$\""},
+		{"region?", []string{""}, ""},
+		{"region?", nil, "region missing"},
+	} {
+		expectation, err := parseExpectation(testPair.Expectation)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		result := expectation.problem(testPair.Values)
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+type thresholdRangeTestPair struct {
+	Range  string
+	Alerts []float64
+	OK     []float64
+	Err    error
+}
+
+func TestThresholdRange(t *testing.T) {
+	for _, testPair := range []thresholdRangeTestPair{
+		{"10", []float64{-1, 11}, []float64{0, 5, 10}, nil},
+		{"10:", []float64{9}, []float64{10, 1e9}, nil},
+		{"~:10", []float64{11}, []float64{-1e9, 10}, nil},
+		{"10:20", []float64{9, 21}, []float64{10, 20}, nil},
+		{"@10:20", []float64{10, 15, 20}, []float64{9, 21}, nil},
+		{"", nil, []float64{-1, 0, 1e9}, nil},
+		{"20:10", nil, nil, errors.New("Backwards")},
+		{":10", nil, nil, errors.New("No start")},
+		{"ten", nil, nil, errors.New("Not a number")},
+		{"1:x", nil, nil, errors.New("Not a number")},
+	} {
+		result, err := parseThresholdRange(testPair.Range)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err != nil {
+			continue
+		}
+		for _, value := range testPair.Alerts {
+			if !result.alerts(value) {
+				t.Error("Expected an alert for", value, "for", testPair)
+			}
+		}
+		for _, value := range testPair.OK {
+			if result.alerts(value) {
+				t.Error("Unexpected alert for", value, "for", testPair)
+			}
+		}
+	}
+}
+
+const checkTestZone = `
+example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 300
+config.example.com. 300 IN TXT "region=eu"
+config.example.com. 60 IN TXT "mirror=a.example.com"
+config.example.com. 300 IN TXT "mirror=b.example.com"
+config.example.com. 300 IN TXT "port=8080"
+`
+
+type runCheckTestPair struct {
+	Source       string
+	Expectations []string
+	Options      checkOptions
+	Status       checkStatus
+	Problems     []string
+}
+
+func TestRunCheck(t *testing.T) {
+	expiration := time.Now().Add(100 * time.Hour)
+	address, shutdown := startTestServerWith(t, checkTestZone, func(response *dns.Msg) {
+		if len(response.Answer) == 0 || response.IsEdns0() == nil || !response.IsEdns0().Do() {
+			return
+		}
+		header := response.Answer[0].Header()
+		response.Answer = append(response.Answer, &dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: header.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: header.Ttl},
+			TypeCovered: header.Rrtype,
+			Expiration:  uint32(expiration.Unix()),
+		})
+	})
+	defer shutdown()
+
+	for _, testPair := range []runCheckTestPair{
+		{"config.example.com", []string{"region=eu", "mirror=b.example.com", "port~=^[0-9]+$", "port?"}, checkOptions{}, checkOK, nil},
+		{"config.example.com", nil, checkOptions{}, checkOK, nil},
+		{"config.example.com", []string{"region=us", "missing?"}, checkOptions{}, checkCritical, []string{"region is \"eu\", expected \"us\"", "missing missing"}},
+		{"missing.example.com", []string{"region=eu"}, checkOptions{}, checkCritical, []string{"region missing"}},
+		{"config.example.com", []string{"region=eu"}, checkOptions{ttlWarning: "300:", ttlCritical: "30:"}, checkWarning, []string{"TTL 60s outside 300:"}},
+		{"config.example.com", []string{"region=eu"}, checkOptions{ttlWarning: "300:", ttlCritical: "120:"}, checkCritical, []string{"TTL 60s outside 120:"}},
+		{"config.example.com", []string{"region=eu"}, checkOptions{signatureWarning: "168h", signatureCritical: "24h"}, checkWarning, []string{"signature expires at " + expiration.UTC().Format(time.RFC3339)}},
+		{"config.example.com", []string{"region=eu"}, checkOptions{signatureWarning: "72h"}, checkOK, nil},
+		{"gopher://config.example.com", []string{"region=eu"}, checkOptions{}, checkUnknown, []string{"Unsupported URI scheme: gopher"}},
+	} {
+		options := makeDefaultOptions()
+		options.nameservers = []string{address}
+		var expectations []expectation
+		for _, arg := range testPair.Expectations {
+			expectation, err := parseExpectation(arg)
+			if err != nil {
+				t.Fatal("Error", err.Error())
+			}
+			expectations = append(expectations, expectation)
+		}
+		thresholds, err := makeCheckThresholds(&testPair.Options)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+
+		result := runCheck(options, thresholds, testPair.Source, expectations)
+		if result.status != testPair.Status || !reflect.DeepEqual(result.problems, testPair.Problems) {
+			t.Error("Expected", testPair.Status, testPair.Problems, "but got", result.status, result.problems, "for", testPair)
+		}
+	}
+}
+
+func TestCheckResultString(t *testing.T) {
+	options := makeDefaultOptions()
+	address, shutdown := startTestServer(t, checkTestZone)
+	defer shutdown()
+	options.nameservers = []string{address}
+
+	thresholds, err := makeCheckThresholds(&checkOptions{ttlWarning: "30:"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	region, _ := parseExpectation("region=eu")
+	result := runCheck(options, thresholds, "config.example.com", []expectation{region}).String()
+	if !strings.HasPrefix(result, "SDGET OK - 1 expectation(s) met in config.example.com | time=") || !strings.HasSuffix(result, "s;;;0 records=4;;;0 ttl=60s;30:;;0") {
+		t.Error("Unexpected output", result)
+	}
+
+	if _, err := makeCheckThresholds(&checkOptions{signatureCritical: "3d"}); err == nil {
+		t.Error("Expected error not caught for --signature-critical 3d")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+// Shell completion (--completion-script-bash, --completion-script-zsh, --completion-script-fish)
+//
+// kingpin does the work, but its own scripts pass it the word being completed, which it then counts as a complete
+// argument, so these scripts only pass it complete words.  Keys are completed by looking up the source, and can contain
+// spaces, so candidates are read one per line and escaped for the shell.
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Completion shouldn't leave the shell hanging on a slow nameserver
+const completionTimeout = 1 * time.Second
+
+// Schemes of the URIs that getTxtProvider supports
+var sourceSchemes = []string{"dns:", "file:", "ip:"}
+
+const bashCompletionTemplate = `
+_{{.App.Name}}_bash_autocomplete() {
+    local line cur opts opt
+    local -a words
+    line="${COMP_LINE:0:$COMP_POINT}"
+    # Without -r, so that escaped spaces stay in their words
+    read -a words <<< "$line"
+    cur=""
+    if [[ "$line" != *[[:space:]] ]]; then
+        cur="${words[${#words[@]}-1]}"
+        unset 'words[${#words[@]}-1]'
+    fi
+    if [[ "$cur" == -* ]]; then
+        opts=$( "${words[0]}" --completion-bash "${words[@]:1}" "$cur" 2>/dev/null )
+    else
+        opts=$( "${words[0]}" --completion-bash "${words[@]:1}" "" 2>/dev/null )
+    fi
+    # One candidate per line, since keys can contain spaces
+    COMPREPLY=()
+    while IFS= read -r opt; do
+        [[ -n "$opt" && "$opt" == "$cur"* ]] && COMPREPLY+=( "$opt" )
+    done <<< "$opts"
+    # Bash only replaces the part of the word after the last :
+    if [[ "$cur" == *:* && "$COMP_WORDBREAKS" == *:* ]]; then
+        local prefix="${cur%"${cur##*:}"}"
+        COMPREPLY=( "${COMPREPLY[@]#"$prefix"}" )
+    fi
+    # Bash inserts candidates as they are, so escape them
+    local i
+    for i in "${!COMPREPLY[@]}"; do
+        printf -v 'COMPREPLY[i]' '%q' "${COMPREPLY[i]}"
+    done
+    return 0
+}
+complete -F _{{.App.Name}}_bash_autocomplete {{.App.Name}}
+`
+
+const zshCompletionTemplate = `#compdef {{.App.Name}}
+
+_{{.App.Name}}() {
+    local -a opts
+    # words are as typed, so they're unquoted, and compadd quotes the candidates
+    if [[ "${words[CURRENT]}" == -* ]]; then
+        opts=(${(f)"$(${words[1]} --completion-bash ${(Q)words[2,CURRENT]} 2>/dev/null)"})
+    else
+        opts=(${(f)"$(${words[1]} --completion-bash ${(Q)words[2,CURRENT-1]} '' 2>/dev/null)"})
+    fi
+    compadd -a opts
+}
+(( $+functions[compdef] )) || { autoload -U compinit && compinit }
+compdef _{{.App.Name}} {{.App.Name}}
+`
+
+const fishCompletionScript = `function __%[1]s_complete
+    set -l words (commandline -opc)
+    set -l command $words[1]
+    set -e words[1]
+    set -l current (commandline -ct)
+    if string match -q -- '-*' $current
+        $command --completion-bash $words $current 2>/dev/null
+    else
+        $command --completion-bash $words '' 2>/dev/null
+    end
+end
+complete -c %[1]s -f -a '(__%[1]s_complete)'
+`
+
+// Replaces kingpin's completion scripts, and adds one for fish
+func setUpCompletion(app *kingpin.Application) {
+	kingpin.BashCompletionTemplate = bashCompletionTemplate
+	kingpin.ZshCompletionTemplate = zshCompletionTemplate
+	app.Flag("completion-script-fish", "Generate completion script for fish.").Hidden().PreAction(func(*kingpin.ParseContext) error {
+		fmt.Fprintf(os.Stdout, fishCompletionScript, app.Name)
+		os.Exit(0)
+		return nil
+	}).Bool()
+}
+
+func completeSources(options *options) []string {
+	completions := append([]string{}, sourceSchemes...)
+	for name := range options.aliases {
+		completions = append(completions, "@"+name)
+	}
+	sort.Strings(completions[len(sourceSchemes):])
+	return completions
+}
+
+// Commands are offered too if there isn't one yet, since kingpin only offers the arguments of the default command
+func completeFirstArgument(options *options, app *kingpin.Application, args []string) []string {
+	completions := completeSources(options)
+	if firstArgument(app, args) == "" {
+		for _, command := range app.Model().Commands {
+			if !command.Hidden {
+				completions = append(completions, command.Name)
+			}
+		}
+	}
+	return completions
+}
+
+// The first (complete) argument that isn't a flag or a flag's value
+func firstArgument(app *kingpin.Application, args []string) string {
+	takesValue := func(flag *kingpin.FlagModel) bool {
+		boolFlag, ok := flag.Value.(interface{ IsBoolFlag() bool })
+		return !ok || !boolFlag.IsBoolFlag()
+	}
+	flags := app.Model().Flags
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "" || !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+		for _, flag := range flags {
+			long := strings.HasPrefix(arg, "--") && arg[2:] == flag.Name
+			short := flag.Short != 0 && arg == "-"+string(flag.Short)
+			if (long || short) && takesValue(flag) {
+				i++
+			}
+		}
+	}
+	return ""
+}
+
+// The keys in source.  Any error just means there's nothing to suggest.
+func completeKeys(options *options, source string) []string {
+	if source == "" {
+		return nil
+	}
+	completionOptions := *options
+	options = &completionOptions
+	options.queryTimeout = completionTimeout
+	if options.tsigKeyFile != "" && options.tsig == nil {
+		var err error
+		if options.tsig, err = loadTsigKey(options.tsigKeyFile); err != nil {
+			return nil
+		}
+	}
+	provider, err := getTxtProvider(options, source)
+	if err != nil {
+		return nil
+	}
+	txtRecords, err := provider.getTxtRecords()
+	if err != nil {
+		return nil
+	}
+
+	var keys []string
+	seen := map[string]bool{includeKey: true}
+	for _, record := range namespacedRecords(options, txtRecords) {
+		isRecord, key, _ := splitRecord(record)
+		if isRecord && key != "" && !seen[normalizeKey(options, key)] {
+			seen[normalizeKey(options, key)] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const completionTestZone = `
+example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 300
+config.example.com. 300 IN TXT "region=eu"
+config.example.com. 300 IN TXT "mirror=a.example.com"
+config.example.com. 300 IN TXT "Mirror=b.example.com"
+config.example.com. 300 IN TXT "cert@from=2026-11-01=new"
+config.example.com. 300 IN TXT "env[region=eu]=prod"
+config.example.com. 300 IN TXT "_include=common.example.com"
+config.example.com. 300 IN TXT "not a record"
+config.example.com. 300 IN TXT "myapp:port=8080"
+`
+
+type completeKeysTestPair struct {
+	Namespace string
+	Source    string
+	Result    []string
+}
+
+func TestCompleteKeys(t *testing.T) {
+	address, shutdown := startTestServer(t, completionTestZone)
+	defer shutdown()
+
+	for _, testPair := range []completeKeysTestPair{
+		{"", "config.example.com", []string{"cert", "env", "mirror", "myapp:port", "region"}},
+		{"myapp", "config.example.com", []string{"port"}},
+		{"", "missing.example.com", nil},
+		{"", "gopher://config.example.com", nil},
+		{"", "", nil},
+	} {
+		options := makeDefaultOptions()
+		options.nameservers = []string{address}
+		options.namespace = testPair.Namespace
+		result := completeKeys(options, testPair.Source)
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestCompleteKeysTimeout(t *testing.T) {
+	// Never answers
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	defer packetConn.Close()
+
+	options := makeDefaultOptions()
+	options.nameservers = []string{packetConn.LocalAddr().String()}
+	start := time.Now()
+	if result := completeKeys(options, "config.example.com"); result != nil {
+		t.Error("Expected no keys but got", result)
+	}
+	if elapsed := time.Since(start); elapsed >= exchangeTimeout {
+		t.Error("Expected the completion timeout but took", elapsed)
+	}
+	if options.queryTimeout != 0 {
+		t.Error("Expected the options to be left alone but got a timeout of", options.queryTimeout)
+	}
+}
+
+type firstArgumentTestPair struct {
+	Args   []string
+	Result string
+}
+
+func TestCompleteFirstArgument(t *testing.T) {
+	options := makeDefaultOptions()
+	options.aliases = map[string]string{"web": "web.example.com", "billing": "billing.example.com"}
+	app := kingpin.New("sdget", "")
+	app.Flag("format", "").Short('f').String()
+	app.Flag("trim", "").Bool()
+	app.Flag("verbose", "").Short('v').Counter()
+	app.Command("get", "").Default()
+	app.Command("doctor", "")
+	app.Command("hidden", "").Hidden()
+
+	for _, testPair := range []firstArgumentTestPair{
+		{[]string{""}, ""},
+		{[]string{"--format", "json", ""}, ""},
+		{[]string{"-f", "json", "-v", "--trim", ""}, ""},
+		{[]string{"--format=json", "get", ""}, "get"},
+		{[]string{"-v", "example.com", ""}, "example.com"},
+	} {
+		result := firstArgument(app, testPair.Args)
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+
+	expected := []string{"dns:", "file:", "ip:", "@billing", "@web", "get", "doctor"}
+	if result := completeFirstArgument(options, app, []string{"-v", ""}); !reflect.DeepEqual(result, expected) {
+		t.Error("Expected", expected, "but got", result)
+	}
+	expected = []string{"dns:", "file:", "ip:", "@billing", "@web"}
+	if result := completeFirstArgument(options, app, []string{"get", ""}); !reflect.DeepEqual(result, expected) {
+		t.Error("Expected", expected, "but got", result)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+// Config file (--config, --profile): defaults for flags, grouped in named profiles, and aliases for sources
+//
+//	[aliases]
+//	billing = "dns://ns1.example.com/billing.cfg.example.com"
+//
+//	[profiles.default]
+//	nameserver = ["ns1.example.com", "ns2.example.com"]
+//	tsig-key = "billing.key"
+//
+// Profiles only change the defaults of flags, so the command line and SDGET_* environment variables still take
+// precedence.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Used when there's no --profile, if the config file has it
+const defaultProfile = "default"
+
+// Flags that can't go in a profile
+var unprofiledFlags = map[string]bool{"config": true, "profile": true, "help": true, "help-long": true, "help-man": true, "version": true, "verbose": true}
+
+// Settings that are file names, which are relative to the config file
+var pathSettings = map[string]bool{"schema": true, "tsig-key": true}
+
+type config struct {
+	path     string
+	aliases  map[string]string
+	profiles map[string][]setting
+}
+
+// A flag's values in a profile
+type setting struct {
+	name   string
+	values []string
+}
+
+// $XDG_CONFIG_HOME/sdget/config.toml, or ~/.config/sdget/config.toml
+func defaultConfigPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "sdget", "config.toml")
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "sdget", "config.toml")
+	}
+	return ""
+}
+
+// Finds the value of a flag before kingpin parses the command line, since the config file changes the flags' defaults
+func scanFlag(args []string, name string, envar string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+name && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if strings.HasPrefix(arg, "--"+name+"=") {
+			return arg[len(name)+3:], true
+		}
+	}
+	if value := os.Getenv(envar); value != "" {
+		return value, true
+	}
+	return "", false
+}
+
+// Loads the config file chosen by --config, or the default one if it exists
+func findConfig(args []string) (*config, error) {
+	path, explicit := scanFlag(args, "config", "SDGET_CONFIG")
+	if !explicit {
+		path = defaultConfigPath()
+		if _, err := os.Stat(path); path == "" || os.IsNotExist(err) {
+			return &config{}, nil
+		}
+	}
+	return loadConfig(path)
+}
+
+func loadConfig(path string) (*config, error) {
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading config file %s", path)
+	}
+	config, err := makeConfig(tree)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error in config file %s", path)
+	}
+	config.path = path
+	return config, nil
+}
+
+// In the order they're in the file, which matters for transforms like --split
+func sortedKeys(tree *toml.Tree) []string {
+	keys := tree.Keys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := tree.GetPositionPath([]string{keys[i]}), tree.GetPositionPath([]string{keys[j]})
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+	return keys
+}
+
+func makeConfig(tree *toml.Tree) (*config, error) {
+	config := &config{aliases: make(map[string]string), profiles: make(map[string][]setting)}
+	for _, section := range sortedKeys(tree) {
+		table, ok := tree.GetPath([]string{section}).(*toml.Tree)
+		if !ok {
+			return nil, errors.Errorf("%s: \"%s\" isn't a table", tree.GetPositionPath([]string{section}), section)
+		}
+		switch section {
+		case "aliases":
+			for _, name := range sortedKeys(table) {
+				target, ok := table.GetPath([]string{name}).(string)
+				if !ok || target == "" || strings.HasPrefix(target, "@") {
+					return nil, errors.Errorf("%s: alias \"%s\" should be a URI or domain name", table.GetPositionPath([]string{name}), name)
+				}
+				config.aliases[name] = target
+			}
+
+		case "profiles":
+			for _, name := range sortedKeys(table) {
+				profile, ok := table.GetPath([]string{name}).(*toml.Tree)
+				if !ok {
+					return nil, errors.Errorf("%s: profile \"%s\" isn't a table", table.GetPositionPath([]string{name}), name)
+				}
+				var settings []setting
+				for _, key := range sortedKeys(profile) {
+					values, err := settingValues(profile.GetPath([]string{key}))
+					if err != nil {
+						return nil, errors.Wrapf(err, "%s: invalid \"%s\" in profile \"%s\"", profile.GetPositionPath([]string{key}), key, name)
+					}
+					settings = append(settings, setting{key, values})
+				}
+				config.profiles[name] = settings
+			}
+
+		default:
+			return nil, errors.Errorf("%s: unknown section \"%s\" (expected aliases or profiles)", tree.GetPositionPath([]string{section}), section)
+		}
+	}
+	return config, nil
+}
+
+// Converts a TOML value to strings, like they'd be written on the command line
+func settingValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case time.Time:
+		return []string{v.Format(time.RFC3339)}, nil
+	case []interface{}:
+		var results []string
+		for _, item := range v {
+			if _, isList := item.([]interface{}); isList {
+				return nil, errors.New("nested lists aren't supported")
+			}
+			values, err := settingValues(item)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, values...)
+		}
+		return results, nil
+	}
+	return nil, errors.Errorf("unsupported type %T", value)
+}
+
+// Relative paths are relative to the config file, and ~/ is the home directory
+func configPath(config *config, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if filepath.IsAbs(path) || config.path == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(config.path), path)
+}
+
+// Chooses the profile from --profile, and uses its settings as the defaults of the app's flags
+func applyProfile(app *kingpin.Application, config *config, args []string) (string, error) {
+	name, explicit := scanFlag(args, "profile", "SDGET_PROFILE")
+	if !explicit {
+		name = defaultProfile
+	}
+	settings, ok := config.profiles[name]
+	if !ok {
+		if !explicit {
+			return "", nil
+		}
+		if config.path == "" {
+			return "", errors.Errorf("profile \"%s\" not found (no config file at %s)", name, defaultConfigPath())
+		}
+		return "", errors.Errorf("profile \"%s\" not found in %s", name, config.path)
+	}
+
+	for _, setting := range settings {
+		flag := app.GetFlag(setting.name)
+		if flag == nil || unprofiledFlags[setting.name] {
+			return "", errors.Errorf("unknown setting \"%s\" in profile \"%s\" of %s", setting.name, name, config.path)
+		}
+		values := setting.values
+		if pathSettings[setting.name] {
+			for i := range values {
+				values[i] = configPath(config, values[i])
+			}
+		}
+		// kingpin sets defaults in no particular order, so transforms are added straight away, in the profile's order
+		// (before any on the command line)
+		if transform, ok := flag.Model().Value.(*transformValue); ok {
+			for _, value := range values {
+				if err := transform.Set(value); err != nil {
+					return "", errors.Wrapf(err, "invalid \"%s\" in profile \"%s\" of %s", setting.name, name, config.path)
+				}
+			}
+			continue
+		}
+		flag.Default(values...)
+	}
+	return name, nil
+}
+
+// kingpin reads arguments from a file for @file, so aliases have to be replaced before it sees them
+func expandAliasArgs(config *config, args []string) []string {
+	results := make([]string, len(args))
+	for i, arg := range args {
+		results[i] = arg
+		if target, ok := config.aliases[strings.TrimPrefix(arg, "@")]; ok && strings.HasPrefix(arg, "@") {
+			results[i] = target
+		}
+	}
+	return results
+}
+
+// For aliases that aren't on the command line, e.g., in _include records or references
+func resolveAlias(options *options, source string) (string, error) {
+	if !strings.HasPrefix(source, "@") {
+		return source, nil
+	}
+	target, ok := options.aliases[source[1:]]
+	if !ok {
+		return "", errors.Errorf("unknown source alias %s", source)
+	}
+	return target, nil
+}
+
+// Where each flag's value came from
+func settingOrigin(flag *kingpin.FlagModel, set map[string]bool, settings []setting, profile string) string {
+	inProfile := false
+	for _, setting := range settings {
+		inProfile = inProfile || setting.name == flag.Name
+	}
+	_, isTransform := flag.Value.(*transformValue)
+	switch {
+	case set[flag.Name] && inProfile && isTransform:
+		return fmt.Sprintf("profile \"%s\", then command line", profile)
+	case set[flag.Name]:
+		return "command line"
+	case flag.Envar != "" && os.Getenv(flag.Envar) != "":
+		return flag.Envar
+	case inProfile:
+		return fmt.Sprintf("profile \"%s\"", profile)
+	case len(flag.Default) > 0:
+		return "default"
+	}
+	return "unset"
+}
+
+// For sdget config show
+func showConfig(sink io.Writer, app *kingpin.Application, config *config, profile string, args []string) error {
+	set := make(map[string]bool)
+	if context, err := app.ParseContext(args); err == nil {
+		for _, element := range context.Elements {
+			if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+				set[flag.Model().Name] = true
+			}
+		}
+	}
+
+	path := config.path
+	if path == "" {
+		path = "(none)"
+	}
+	profileName := profile
+	if profileName == "" {
+		profileName = "(none)"
+	}
+	fmt.Fprintf(sink, "config file: %s\nprofile: %s\n\n", path, profileName)
+
+	writer := tabwriter.NewWriter(sink, 0, 8, 2, ' ', 0)
+	for _, flag := range app.Model().Flags {
+		if unprofiledFlags[flag.Name] || flag.Hidden {
+			continue
+		}
+		value := flag.String()
+		if flag.Name == "salt" && value != "" {
+			value = redacted
+		}
+		fmt.Fprintf(writer, "%s\t%s\t(%s)\n", flag.Name, value, settingOrigin(flag, set, config.profiles[profile], profile))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	if len(config.aliases) > 0 {
+		fmt.Fprintln(sink, "\naliases:")
+		var names []string
+		for name := range config.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		writer = tabwriter.NewWriter(sink, 0, 8, 2, ' ', 0)
+		for _, name := range names {
+			fmt.Fprintf(writer, "@%s\t%s\n", name, config.aliases[name])
+		}
+		return writer.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type makeConfigTestPair struct {
+	Input    string
+	Aliases  map[string]string
+	Profiles map[string][]setting
+	Err      error
+}
+
+func TestMakeConfig(t *testing.T) {
+	for _, testPair := range []makeConfigTestPair{
+		{"", map[string]string{}, map[string][]setting{}, nil},
+		{
+			"[aliases]\nbilling = \"dns://ns1/billing.cfg.example.com\"\nweb = \"web.example.com\"\n",
+			map[string]string{"billing": "dns://ns1/billing.cfg.example.com", "web": "web.example.com"},
+			map[string][]setting{},
+			nil,
+		},
+		{
+			"[profiles.default]\ntype = \"list\"\nsplit = \",\"\ntrim = true\nrace = 2\nnameserver = [\"ns1\", \"ns2\"]\nat = 2026-11-01T09:30:00Z\n\n[profiles.staging]\nat = \"2026-11-01\"\n",
+			map[string]string{},
+			map[string][]setting{
+				"default": {{"type", []string{"list"}}, {"split", []string{","}}, {"trim", []string{"true"}}, {"race", []string{"2"}}, {"nameserver", []string{"ns1", "ns2"}}, {"at", []string{"2026-11-01T09:30:00Z"}}},
+				"staging": {{"at", []string{"2026-11-01"}}},
+			},
+			nil,
+		},
+		{"[profiles.default]\nsplit = \",\"\n\n[profiles.default]\ntrim = true\n", nil, nil, errors.New("Repeated table")},
+		{"[profile.default]\ntype = \"list\"\n", nil, nil, errors.New("Unknown section")},
+		{"aliases = \"web.example.com\"\n", nil, nil, errors.New("Not a table")},
+		{"[aliases]\nweb = 1\n", nil, nil, errors.New("Not a string")},
+		{"[aliases]\nweb = \"@other\"\n", nil, nil, errors.New("Alias of alias")},
+		{"[profiles]\ndefault = \"list\"\n", nil, nil, errors.New("Profile not a table")},
+		{"[profiles.default]\nnameserver = [[\"ns1\"]]\n", nil, nil, errors.New("Nested list")},
+		{"[profiles.default.select]\nenv = \"prod\"\n", nil, nil, errors.New("Table setting")},
+	} {
+		var config *config
+		tree, err := toml.Load(testPair.Input)
+		if err == nil {
+			config, err = makeConfig(tree)
+		}
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err == nil && (!reflect.DeepEqual(config.aliases, testPair.Aliases) || !reflect.DeepEqual(config.profiles, testPair.Profiles)) {
+			t.Error("Expected", testPair.Aliases, testPair.Profiles, "but got", config.aliases, config.profiles, "for", testPair)
+		}
+	}
+}
+
+type scanFlagTestPair struct {
+	Args   []string
+	Envar  string
+	Result string
+	Found  bool
+}
+
+func TestScanFlag(t *testing.T) {
+	for _, testPair := range []scanFlagTestPair{
+		{[]string{"--profile", "staging", "example.com", "key"}, "", "staging", true},
+		{[]string{"example.com", "--profile=staging", "key"}, "", "staging", true},
+		{[]string{"--profile=", "example.com", "key"}, "", "", true},
+		{[]string{"example.com", "key"}, "", "", false},
+		{[]string{"example.com", "key"}, "prod", "prod", true},
+		{[]string{"--profile", "staging", "example.com", "key"}, "prod", "staging", true},
+		{[]string{"--", "--profile", "staging"}, "", "", false},
+		{[]string{"example.com", "key", "--profile"}, "", "", false},
+	} {
+		os.Setenv("SDGET_TEST_PROFILE", testPair.Envar)
+		result, found := scanFlag(testPair.Args, "profile", "SDGET_TEST_PROFILE")
+		if result != testPair.Result || found != testPair.Found {
+			t.Error("Expected", testPair.Result, testPair.Found, "but got", result, found, "for", testPair)
+		}
+	}
+	os.Unsetenv("SDGET_TEST_PROFILE")
+}
+
+const testConfig = `
+[aliases]
+billing = "dns://ns1.example.com/billing.cfg.example.com"
+
+[profiles.default]
+type = "list"
+split = ","
+trim = true
+tsig-key = "keys/billing.key"
+
+[profiles.staging]
+format = "json"
+nameserver = ["ns1.example.com", "ns2.example.com"]
+`
+
+// Sets up an app with some of sdget's flags and the profile, and parses args
+func parseWithProfile(t *testing.T, args []string) (*options, *kingpin.Application, string, error) {
+	tree, err := toml.Load(testConfig)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	config, err := makeConfig(tree)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	config.path = "/etc/sdget/config.toml"
+
+	options := makeDefaultOptions()
+	app := kingpin.New("sdget", "")
+	app.Flag("format", "").Default("plain").Envar("SDGET_TEST_FORMAT").EnumVar(&options.outputFormat, "json", "plain", "zero")
+	app.Flag("nameserver", "").StringsVar(&options.nameservers)
+	app.Flag("profile", "").String()
+	app.Flag("sort", "").SetValue(&transformValue{&options.transforms, "--sort", sortTransform, true})
+	app.Flag("split", "").SetValue(&transformValue{&options.transforms, "--split", splitTransform, false})
+	app.Flag("trim", "").SetValue(&transformValue{&options.transforms, "--trim", trimTransform, true})
+	app.Flag("tsig-key", "").StringVar(&options.tsigKeyFile)
+	app.Flag("type", "").Default("single").EnumVar(&options.valueType, "single", "list")
+	app.Arg("source", "").String()
+
+	profile, err := applyProfile(app, config, args)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	_, err = app.Parse(expandAliasArgs(config, args))
+	return options, app, profile, err
+}
+
+func TestApplyProfile(t *testing.T) {
+	options, _, profile, err := parseWithProfile(t, []string{"@billing"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if profile != "default" || options.valueType != "list" || options.outputFormat != "plain" || options.tsigKeyFile != filepath.FromSlash("/etc/sdget/keys/billing.key") {
+		t.Error("Expected the default profile but got", profile, options.valueType, options.outputFormat, options.tsigKeyFile)
+	}
+	if values := applyTransforms(options, []string{"b, a"}); !reflect.DeepEqual(values, []string{"b", "a"}) {
+		t.Error("Expected [b a] from the profile's transforms but got", values)
+	}
+
+	// Transforms on the command line come after the profile's
+	options, _, _, err = parseWithProfile(t, []string{"--sort", "@billing"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if values := applyTransforms(options, []string{"b, a"}); !reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Error("Expected [a b] from the profile's and command line's transforms but got", values)
+	}
+
+	options, _, profile, err = parseWithProfile(t, []string{"--profile=staging", "--type", "list", "@billing"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if profile != "staging" || options.valueType != "list" || options.outputFormat != "json" || !reflect.DeepEqual(options.nameservers, []string{"ns1.example.com", "ns2.example.com"}) {
+		t.Error("Expected the staging profile but got", profile, options.valueType, options.outputFormat, options.nameservers)
+	}
+	if len(options.transforms) != 0 || options.tsigKeyFile != "" {
+		t.Error("Expected nothing from the default profile but got", options.transforms, options.tsigKeyFile)
+	}
+
+	// The command line beats the environment, which beats the profile
+	os.Setenv("SDGET_TEST_FORMAT", "zero")
+	defer os.Unsetenv("SDGET_TEST_FORMAT")
+	options, _, _, err = parseWithProfile(t, []string{"--profile", "staging", "@billing"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if options.outputFormat != "zero" {
+		t.Error("Expected the environment to override the profile, but got", options.outputFormat)
+	}
+	options, _, _, err = parseWithProfile(t, []string{"--profile", "staging", "--format", "plain", "@billing"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if options.outputFormat != "plain" {
+		t.Error("Expected the command line to override the environment, but got", options.outputFormat)
+	}
+
+	if _, _, _, err = parseWithProfile(t, []string{"--profile", "production", "@billing"}); err == nil {
+		t.Error("Expected error not caught for missing profile")
+	}
+}
+
+func TestApplyProfileUnknownSetting(t *testing.T) {
+	for _, input := range []string{
+		"[profiles.default]\nformt = \"json\"\n",
+		"[profiles.default]\nverbose = 2\n",
+		"[profiles.default]\nprofile = \"staging\"\n",
+	} {
+		tree, err := toml.Load(input)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		config, err := makeConfig(tree)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		app := kingpin.New("sdget", "")
+		app.Flag("format", "").String()
+		app.Flag("profile", "").String()
+		app.Flag("verbose", "").Counter()
+		if _, err := applyProfile(app, config, nil); err == nil {
+			t.Error("Expected error not caught for", input)
+		}
+	}
+}
+
+func TestResolveAlias(t *testing.T) {
+	options := makeDefaultOptions()
+	options.aliases = map[string]string{"billing": "dns://ns1/billing.cfg.example.com"}
+	for source, expected := range map[string]string{
+		"@billing":         "dns://ns1/billing.cfg.example.com",
+		"billing":          "billing",
+		"file:///tmp/test": "file:///tmp/test",
+	} {
+		result, err := resolveAlias(options, source)
+		if err != nil || result != expected {
+			t.Error("Expected", expected, "but got", result, err, "for", source)
+		}
+	}
+	if _, err := resolveAlias(options, "@web"); err == nil {
+		t.Error("Expected error not caught for unknown alias")
+	}
+
+	args := expandAliasArgs(&config{aliases: options.aliases}, []string{"--nameserver", "ns2", "@billing", "key", "@billing2"})
+	if !reflect.DeepEqual(args, []string{"--nameserver", "ns2", "dns://ns1/billing.cfg.example.com", "key", "@billing2"}) {
+		t.Error("Unexpected expanded args", args)
+	}
+}
+
+func TestShowConfig(t *testing.T) {
+	options, app, profile, err := parseWithProfile(t, []string{"--profile", "staging", "--type=list", "@billing"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	tree, _ := toml.Load(testConfig)
+	config, _ := makeConfig(tree)
+	config.path = "/etc/sdget/config.toml"
+
+	var sink bytes.Buffer
+	if err := showConfig(&sink, app, config, profile, []string{"--profile", "staging", "--type=list", "billing.example.com"}); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	for _, expected := range []string{
+		"config file: /etc/sdget/config.toml\n",
+		"profile: staging\n",
+		"format      json                             (profile \"staging\")\n",
+		"nameserver  ns1.example.com,ns2.example.com  (profile \"staging\")\n",
+		"type        list                             (command line)\n",
+		"split                                        (unset)\n",
+		"@billing  dns://ns1.example.com/billing.cfg.example.com\n",
+	} {
+		if !strings.Contains(sink.String(), expected) {
+			t.Error("Expected", expected, "in", sink.String())
+		}
+	}
+	if options.valueType != "list" {
+		t.Error("Expected list but got", options.valueType)
+	}
+}
@@ -3,54 +3,127 @@ package main
 import (
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
+	"golang.org/x/net/idna"
 )
 
+// Stops CNAME loops
+const maxCNAMEs = 16
+
 type dnsProvider struct {
-	options    *options
-	nameserver string
-	domain     string
+	options     *options
+	nameservers []string
+	domain      string
+	qclass      uint16
+	qtype       uint16
+	recurse     bool
+	followCNAME bool // Look up CNAME targets that the nameserver didn't (e.g., with --authoritative)
+	cnames      int  // CNAMEs followed so far
 }
 
-func makeDnsProvider(options *options, nameserver string, domain string) (*dnsProvider, error) {
+func makeDnsProvider(options *options, nameserver string, domain string, qclass uint16, qtype uint16) (*dnsProvider, error) {
 	if domain == "" {
 		return nil, errors.New("non-empty domain name required")
 	}
-	nameserver, err := canonicalNameserver(options, nameserver)
+	domain, err := idnaToASCII(domain)
 	if err != nil {
+		return nil, errors.Wrap(err, "error converting internationalized domain name")
+	}
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return nil, errors.Errorf("invalid domain name: %s", domain)
+	}
+	if !isStringType(qtype) {
+		return nil, errors.Errorf("records of type %s can't be read as strings", dns.Type(qtype))
+	}
+	var nameservers []string
+	if options.resolver == "system" {
+		if err := checkSystemQuery(nameserver, qclass, qtype); err != nil {
+			return nil, err
+		}
+	} else if nameservers, err = canonicalNameservers(options, nameserver); err != nil {
 		return nil, errors.Wrap(err, "error configuring DNS client")
 	}
 	if !strings.HasSuffix(domain, ".") {
 		domain = domain + "."
 	}
 	return &dnsProvider{
-		options:    options,
-		nameserver: nameserver,
-		domain:     domain,
+		options:     options,
+		nameservers: nameservers,
+		domain:      domain,
+		qclass:      qclass,
+		qtype:       qtype,
+		recurse:     true,
 	}, nil
 }
 
 func (d *dnsProvider) getTxtRecords() ([]string, error) {
-	query := new(dns.Msg)
-	query.SetQuestion(d.domain, dns.TypeTXT)
-	query.RecursionDesired = true
+	provider, servers, err := d.resolveServers()
+	if err != nil {
+		return nil, err
+	}
+	if perKeyLayout(d.options) {
+		return provider.getPerNameTxtRecordsFrom(servers)
+	}
+	return provider.getTxtRecordsFrom(servers)
+}
+
+// Only fetches the records that could match key, which is less than the whole table with --layout per-name or hashed
+func (d *dnsProvider) getKeyTxtRecords(key string) ([]string, error) {
+	provider, servers, err := d.resolveServers()
+	if err != nil {
+		return nil, err
+	}
+	return provider.getKeyTxtRecordsFrom(key, servers)
+}
 
-	client := new(dns.Client)
+// The nameservers to query.  With --authoritative, these bypass caching resolvers, so the zone's authoritative servers
+// are asked directly, without recursion.
+func (d *dnsProvider) resolveServers() (*dnsProvider, []string, error) {
+	if !d.options.authoritative {
+		return d, d.nameservers, nil
+	}
+	_, servers, err := findAuthoritativeServers(d.options, d.nameservers[0], d.domain)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error finding authoritative servers")
+	}
+	authoritative := *d
+	authoritative.recurse = false
+	return &authoritative, servers, nil
+}
+
+// Queries the nameservers in turn (or races them, with --race), until one gives a usable answer
+// Non-recursive queries are only sent to authoritative servers, so the answer must have the AA bit.
+func (d *dnsProvider) getTxtRecordsFrom(nameservers []string) ([]string, error) {
+	if d.options.resolver == "system" {
+		return systemLookupTXT(d.options, d.domain)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(d.domain, d.qtype)
+	query.Question[0].Qclass = d.qclass
+	query.RecursionDesired = d.recurse
 
 	// Always use TCP since some of our values will otherwise get truncated,
 	// and this is simpler than trying UDP and falling back. We are not using this in
 	// a performant sensitive context.
-	client.Net = "tcp"
-
-	response, _, err := client.Exchange(query, d.nameserver)
+	requestNsid(d.options, query)
+	requestSignatures(d.options, query)
+	signQuery(d.options, query)
+	response, err := exchangeAny(d.options, query, nameservers, "tcp", func(nameserver string, response *dns.Msg) error {
+		if !d.recurse && !response.Authoritative {
+			return errors.Errorf("answer from %s is not authoritative (AA bit not set)", nameserver)
+		}
+		return usableResponse(nameserver, response)
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "error executing DNS query")
+		return nil, err
 	}
 
 	switch response.Rcode {
@@ -60,52 +133,209 @@ func (d *dnsProvider) getTxtRecords() ([]string, error) {
 	case dns.RcodeNameError: // a.k.a. NXDOMAIN
 		// TODO: add an option to allow ignoring this
 		// This is the default for safety reasons
-		return nil, errors.Errorf("no TXT records for domain %s", d.domain)
+		return nil, &noRecordsError{d.qtype, d.domain}
 
 	default:
 		return nil, errors.Errorf("error from remote DNS server: %s", dns.RcodeToString[response.Rcode])
 	}
+	if d.options.onResponse != nil {
+		d.options.onResponse(response)
+	}
 
 	var results []string
 	for _, answer := range response.Answer {
-		if txt, ok := answer.(*dns.TXT); ok {
-			quotedRecord := strings.Join(txt.Txt, "")
-			unquoted, err := miekgUnquoteTxt(quotedRecord)
-			if err != nil {
-				return nil, errors.Wrapf(err, "error trying to unquote TXT record \"%s\"", quotedRecord)
-			}
-			results = append(results, unquoted)
+		header := answer.Header()
+		if header.Rrtype != d.qtype || header.Class != d.qclass {
+			continue
+		}
+		record, err := recordString(answer)
+		if err != nil {
+			return nil, err
 		}
+		results = append(results, record)
 	}
 
+	if len(results) == 0 && d.followCNAME {
+		if target := cnameTarget(response, d.domain); target != "" {
+			return d.followCNAMETo(target)
+		}
+	}
 	return results, nil
 }
 
-func canonicalNameserver(options *options, nameserver string) (string, error) {
-	if nameserver == "" {
-		if options.nameserver == "" {
-			return configFromResolvConf(options)
+// The end of any chain of CNAMEs from domain in the response
+func cnameTarget(response *dns.Msg, domain string) string {
+	name := domain
+	for i := 0; i <= len(response.Answer); i++ {
+		found := false
+		for _, rr := range response.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				name, found = cname.Target, true
+				break
+			}
+		}
+		if !found {
+			break
 		}
-		nameserver = options.nameserver
 	}
-	return addNameserverPort(options, nameserver)
+	if strings.EqualFold(name, domain) {
+		return ""
+	}
+	return name
+}
+
+func (d *dnsProvider) followCNAMETo(target string) ([]string, error) {
+	if d.cnames >= maxCNAMEs {
+		return nil, errors.Errorf("more than %d CNAMEs from %s", maxCNAMEs, d.domain)
+	}
+	tracef(d.options, traceSummary, "following CNAME from %s to %s", d.domain, target)
+	targetProvider := *d
+	targetProvider.domain = target
+	targetProvider.cnames++
+	// The target can be in another zone, with other authoritative servers
+	provider, servers, err := targetProvider.resolveServers()
+	if err != nil {
+		return nil, err
+	}
+	return provider.getTxtRecordsFrom(servers)
+}
+
+// NXDOMAIN.  Missing keys are normal with --layout per-name, so this can be told apart from other failures.
+type noRecordsError struct {
+	qtype  uint16
+	domain string
+}
+
+func (e *noRecordsError) Error() string {
+	return fmt.Sprintf("no %s records for domain %s", dns.Type(e.qtype), e.domain)
 }
 
-func configFromResolvConf(options *options) (string, error) {
+// Record types whose rdata can be used as a key/value string
+func isStringType(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeTXT, dns.TypeSPF, dns.TypeAVC, dns.TypeURI:
+		return true
+	}
+	return false
+}
+
+func recordString(rr dns.RR) (string, error) {
+	var quotedRecord string
+	switch rr := rr.(type) {
+	case *dns.TXT:
+		quotedRecord = strings.Join(rr.Txt, "")
+	case *dns.SPF:
+		quotedRecord = strings.Join(rr.Txt, "")
+	case *dns.AVC:
+		quotedRecord = strings.Join(rr.Txt, "")
+	case *dns.URI:
+		// URI targets are kept as raw octets by miekg/dns, so there's nothing to unquote
+		return rr.Target, nil
+	default:
+		return "", errors.Errorf("unexpected %s record in answer", dns.Type(rr.Header().Rrtype))
+	}
+	unquoted, err := miekgUnquoteTxt(quotedRecord)
+	if err != nil {
+		return "", errors.Wrapf(err, "error trying to unquote %s record \"%s\"", dns.Type(rr.Header().Rrtype), quotedRecord)
+	}
+	return unquoted, nil
+}
+
+// IDNA2008 lookup profile.  New() defaults to UTS #46 nontransitional processing, so ß stays ß.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule())
+
+// Converts any labels containing non-ASCII characters to A-labels (xn--...)
+// ASCII labels are left alone, because the lookup profile would reject things like underscores in _service labels.
+func idnaToASCII(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+	labels := dns.SplitDomainName(domain)
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		aLabel, err := idnaProfile.ToASCII(label)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid label \"%s\"", label)
+		}
+		labels[i] = aLabel
+	}
+	result := strings.Join(labels, ".")
+	if strings.HasSuffix(domain, ".") {
+		result = result + "."
+	}
+	return result, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// The nameservers to use, in order: the one in the URI, or the --nameserver flags, or the system config
+func canonicalNameservers(options *options, nameserver string) ([]string, error) {
+	if nameserver != "" {
+		tracef(options, traceSummary, "using nameserver %s from URI authority", nameserver)
+		nameserver, err := addNameserverPort(options, nameserver)
+		if err != nil {
+			return nil, err
+		}
+		return []string{nameserver}, nil
+	}
+	if len(options.nameservers) > 0 {
+		tracef(options, traceSummary, "using nameservers %s from --nameserver", strings.Join(options.nameservers, ", "))
+		var nameservers []string
+		for _, nameserver := range options.nameservers {
+			nameserver, err := addNameserverPort(options, nameserver)
+			if err != nil {
+				return nil, err
+			}
+			nameservers = append(nameservers, nameserver)
+		}
+		return nameservers, nil
+	}
+	nameservers, err := serversFromResolvConf(options)
+	if err == nil {
+		tracef(options, traceSummary, "using nameservers %s from /etc/resolv.conf", strings.Join(nameservers, ", "))
+	}
+	return nameservers, err
+}
+
+func serversFromResolvConf(options *options) ([]string, error) {
 	resolvconf, err := os.Open("/etc/resolv.conf")
 	if err != nil {
-		return "", errors.Wrap(err, "error opening /etc/resolv.conf")
+		return nil, errors.Wrap(err, "error opening /etc/resolv.conf")
 	}
 	defer resolvconf.Close()
-	return readResolvConf(options, resolvconf)
+	return readResolvConfServers(options, resolvconf)
 }
 
 func readResolvConf(options *options, resolvconf io.Reader) (string, error) {
+	servers, err := readResolvConfServers(options, resolvconf)
+	if err != nil {
+		return "", err
+	}
+	return servers[0], nil
+}
+
+func readResolvConfServers(options *options, resolvconf io.Reader) ([]string, error) {
 	config, err := dns.ClientConfigFromReader(resolvconf)
 	if err != nil {
-		return "", errors.Wrap(err, "error reading resolv.conf DNS configuration")
+		return nil, errors.Wrap(err, "error reading resolv.conf DNS configuration")
+	}
+	if len(config.Servers) == 0 {
+		return nil, errors.New("no nameservers in resolv.conf DNS configuration")
 	}
-	return config.Servers[0] + ":" + config.Port, nil
+	var servers []string
+	for _, server := range config.Servers {
+		servers = append(servers, net.JoinHostPort(server, config.Port))
+	}
+	return servers, nil
 }
 
 // Users can specify the nameserver host and port, or just the host, or neither.
@@ -156,6 +386,7 @@ func addNameserverPort(options *options, nameserver string) (string, error) {
 // characters with byte value below 32 and above 127 => \DDD (three-digit decimal representation of byte value)
 //
 // This function should only fail if there's a bug caused by the original library changing its quoting scheme or something.
+// miekgQuoteTxt() does the reverse.
 var miekgEscapedEntity = regexp.MustCompile(`\\(\\|"|[0-9]{3}|.|$)`)
 
 func miekgUnquoteTxt(quoted string) (string, error) {
@@ -187,3 +418,19 @@ func miekgUnquoteTxt(quoted string) (string, error) {
 	unquoted := miekgEscapedEntity.ReplaceAllStringFunc(quoted, unquoter)
 	return unquoted, err
 }
+
+func miekgQuoteTxt(unquoted string) string {
+	var quoted strings.Builder
+	for _, b := range []byte(unquoted) {
+		switch {
+		case b == '"' || b == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(b)
+		case b < 32 || b > 127:
+			fmt.Fprintf(&quoted, "\\%03d", b)
+		default:
+			quoted.WriteByte(b)
+		}
+	}
+	return quoted.String()
+}
@@ -2,13 +2,99 @@ package main
 
 import (
 	"errors"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/miekg/dns"
 )
 
 var resolvConf = strings.NewReader("nameserver 192.168.7.1\n")
 var defaultNameserver = "192.168.7.1:53"
 
+// Starts a nameserver on a random local port (UDP and TCP) that answers every query using zone (in master file format)
+// Negative answers include the closest enclosing SOA record, and UDP responses are truncated like a real server would.
+func startTestServer(t *testing.T, zone string) (address string, shutdown func()) {
+	return startTestServerWith(t, zone, nil)
+}
+
+// Like startTestServer, but responses are passed through modify before they're sent
+func startTestServerWith(t *testing.T, zone string, modify func(response *dns.Msg)) (address string, shutdown func()) {
+	var records []dns.RR
+	for token := range dns.ParseZone(strings.NewReader(zone), "", "") {
+		if token.Error != nil {
+			t.Fatal("Error", token.Error.Error(), "parsing test zone")
+		}
+		records = append(records, token.RR)
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+		response := testServerResponse(records, query, w.LocalAddr().Network())
+		if modify != nil {
+			modify(response)
+		}
+		w.WriteMsg(response)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error", err.Error(), "starting test server")
+	}
+	packetConn, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error", err.Error(), "starting test server")
+	}
+	tcpServer := &dns.Server{Listener: listener, Handler: handler}
+	udpServer := &dns.Server{PacketConn: packetConn, Handler: handler}
+	go tcpServer.ActivateAndServe()
+	go udpServer.ActivateAndServe()
+	return listener.Addr().String(), func() {
+		tcpServer.Shutdown()
+		udpServer.Shutdown()
+	}
+}
+
+func testServerResponse(records []dns.RR, query *dns.Msg, network string) *dns.Msg {
+	question := query.Question[0]
+	response := new(dns.Msg)
+	response.SetReply(query)
+	response.Authoritative = true
+	response.RecursionAvailable = query.RecursionDesired
+	var zoneSOA dns.RR
+	nameExists := false
+	for _, rr := range records {
+		header := rr.Header()
+		if strings.EqualFold(header.Name, question.Name) {
+			nameExists = true
+			if header.Rrtype == question.Qtype {
+				response.Answer = append(response.Answer, rr)
+			}
+		}
+		isEnclosing := header.Rrtype == dns.TypeSOA && dns.IsSubDomain(header.Name, question.Name)
+		if isEnclosing && (zoneSOA == nil || dns.CountLabel(header.Name) > dns.CountLabel(zoneSOA.Header().Name)) {
+			zoneSOA = rr
+		}
+	}
+	if len(response.Answer) == 0 && zoneSOA != nil {
+		response.Ns = []dns.RR{zoneSOA}
+	}
+	if !nameExists {
+		response.Rcode = dns.RcodeNameError
+	}
+
+	size := dns.MinMsgSize
+	if opt := query.IsEdns0(); opt != nil {
+		response.SetEdns0(opt.UDPSize(), opt.Do())
+		size = int(opt.UDPSize())
+	}
+	if network == "udp" && response.Len() > size {
+		response.Answer = nil
+		response.Truncated = true
+	}
+	return response
+}
+
 type addNameserverPortTestPair struct {
 	Input  string
 	Result string
@@ -48,6 +134,24 @@ func TestReadResolvConf(t *testing.T) {
 	}
 }
 
+func TestReadResolvConfServers(t *testing.T) {
+	options := &options{}
+	input := strings.NewReader("search example.com\nnameserver 192.168.7.1\nnameserver 2001:db8::53\noptions ndots:2\n")
+	servers, err := readResolvConfServers(options, input)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	expected := []string{"192.168.7.1:53", "[2001:db8::53]:53"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Error("Expected", expected, "but got", servers)
+	}
+
+	_, err = readResolvConfServers(options, strings.NewReader("search example.com\n"))
+	if err == nil {
+		t.Error("Expected error for resolv.conf without nameservers")
+	}
+}
+
 type miekgUnquoteTxtTestPair struct {
 	Input  string
 	Result string
@@ -85,3 +189,131 @@ func TestMiekgUnquoteTxt(t *testing.T) {
 
 	}
 }
+
+type makeDnsProviderTestPair struct {
+	Domain string
+	Type   uint16
+	Err    error
+}
+
+func TestMakeDnsProvider(t *testing.T) {
+	for _, testPair := range []makeDnsProviderTestPair{
+		{"example.com", dns.TypeTXT, nil},
+		{"example.com.", dns.TypeSPF, nil},
+		{"_http._tcp.example.com", dns.TypeURI, nil},
+		{"world wide web.example\\.domain.org", dns.TypeTXT, nil},
+		{"", dns.TypeTXT, errors.New("Empty domain")},
+		{"example..com", dns.TypeTXT, errors.New("Invalid domain")},
+		{"example.com", dns.TypeA, errors.New("Not a string type")},
+	} {
+		options := makeDefaultOptions()
+		_, err := makeDnsProvider(options, "127.0.0.1", testPair.Domain, dns.ClassINET, testPair.Type)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+	}
+}
+
+type recordStringTestPair struct {
+	Input  string
+	Result string
+}
+
+func TestRecordString(t *testing.T) {
+	for _, testPair := range []recordStringTestPair{
+		{`example.com. 60 IN TXT "foo=bar"`, "foo=bar"},
+		{`example.com. 60 IN TXT "split " "string"`, "split string"},
+		{`example.com. 60 IN SPF "v=spf1 -all"`, "v=spf1 -all"},
+		{`version.bind. 0 CH TXT "quoted \"version\""`, `quoted "version"`},
+		{`_http._tcp.example.com. 60 IN URI 10 1 "http://www.example.com/path"`, "http://www.example.com/path"},
+	} {
+		rr, err := dns.NewRR(testPair.Input)
+		if err != nil {
+			t.Fatal("Error", err.Error(), "parsing", testPair.Input)
+		}
+		result, err := recordString(rr)
+		if err != nil {
+			t.Error("Error", err.Error(), "for", testPair)
+		}
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+type idnaToASCIITestPair struct {
+	Input  string
+	Result string
+	Err    error
+}
+
+func TestIdnaToASCII(t *testing.T) {
+	for _, testPair := range []idnaToASCIITestPair{
+		{"example.com", "example.com", nil},
+		{"_cfg.Example.com.", "_cfg.Example.com.", nil},
+		{"bücher.example", "xn--bcher-kva.example", nil},
+		{"BÜCHER.example.", "xn--bcher-kva.example.", nil},
+		{"_cfg.faß.de", "_cfg.xn--fa-hia.de", nil},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example", nil},
+		{"a‍b.example", "", errors.New("Invalid joiner")},
+	} {
+		result, err := idnaToASCII(testPair.Input)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestAuthoritativeLookup(t *testing.T) {
+	options, shutdown := startDoctorTestServer(t)
+	defer shutdown()
+	options.authoritative = true
+
+	provider, err := makeDnsProvider(options, "", "config.example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	records, err := provider.getTxtRecords()
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(records, []string{"foo=bar"}) {
+		t.Error("Expected [foo=bar] but got", records)
+	}
+}
+
+func TestNonAuthoritativeAnswerRejected(t *testing.T) {
+	address, shutdown := startTestServerWith(t, doctorTestZone(), func(response *dns.Msg) {
+		response.Authoritative = false
+	})
+	defer shutdown()
+	options := makeDefaultOptions()
+
+	provider, err := makeDnsProvider(options, address, "config.example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if _, err := provider.getTxtRecords(); err != nil {
+		t.Error("Unexpected error for recursive query", err.Error())
+	}
+
+	provider.recurse = false
+	_, err = provider.getTxtRecords()
+	if err == nil || !strings.Contains(err.Error(), "not authoritative") {
+		t.Error("Expected non-authoritative answer to be rejected but got", err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+// sdget doctor: checks for resolver environment problems
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type doctorReport struct {
+	Checks  []doctorCheck  `json:"checks"`
+	Summary map[string]int `json:"summary"`
+}
+
+func (r *doctorReport) add(name string, status string, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, doctorCheck{name, status, fmt.Sprintf(format, args...)})
+	r.Summary[status]++
+}
+
+func (r *doctorReport) failed() bool {
+	return r.Summary[doctorFail] > 0
+}
+
+// Runs all checks against the configured nameservers.  The checks that need real data (response sizes, consistency
+// with the authoritative servers) are only done if a domain is given.
+func runDoctor(options *options, domain string) *doctorReport {
+	report := &doctorReport{Summary: map[string]int{doctorPass: 0, doctorWarn: 0, doctorFail: 0}}
+
+	servers, ok := doctorServers(options, report)
+	if !ok {
+		return report
+	}
+
+	clockChecked := false
+	for _, server := range servers {
+		udpOk := checkReachability(options, report, server, "udp")
+		tcpOk := checkReachability(options, report, server, "tcp")
+		if !udpOk && !tcpOk {
+			continue
+		}
+		network := "udp"
+		if !udpOk {
+			network = "tcp"
+		}
+		if signature := checkEdns(options, report, server, network); signature != nil && !clockChecked {
+			checkClock(report, signature, time.Now())
+			clockChecked = true
+		}
+		if domain != "" {
+			checkResponseSize(options, report, server, dns.Fqdn(domain))
+		}
+	}
+	if !clockChecked {
+		report.add("clock", doctorWarn, "no DNSSEC signatures received, so the clock couldn't be checked")
+	}
+
+	if domain != "" {
+		checkConsistency(options, report, servers[0], dns.Fqdn(domain))
+	}
+	return report
+}
+
+// The nameservers that lookups would use: --nameserver, or everything in resolv.conf
+func doctorServers(options *options, report *doctorReport) ([]string, bool) {
+	if len(options.nameservers) > 0 {
+		var servers []string
+		for _, nameserver := range options.nameservers {
+			server, err := addNameserverPort(options, nameserver)
+			if err != nil {
+				report.add("nameserver", doctorFail, "invalid --nameserver: %s", err.Error())
+				return nil, false
+			}
+			servers = append(servers, server)
+		}
+		report.add("nameserver", doctorPass, "using %s from --nameserver (resolv.conf not checked)", strings.Join(servers, ", "))
+		return servers, true
+	}
+	servers, err := serversFromResolvConf(options)
+	if err != nil {
+		report.add("resolv.conf", doctorFail, "%s", err.Error())
+		return nil, false
+	}
+	report.add("resolv.conf", doctorPass, "nameservers: %s", strings.Join(servers, ", "))
+	return servers, true
+}
+
+func doctorQuery(options *options, server string, network string, name string, qtype uint16, edns bool) (*dns.Msg, time.Duration, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(name, qtype)
+	query.RecursionDesired = true
+	if edns {
+		query.SetEdns0(4096, true)
+	}
+	return exchange(options, query, server, network)
+}
+
+func checkReachability(options *options, report *doctorReport, server string, network string) bool {
+	name := network + " " + server
+	response, rtt, err := doctorQuery(options, server, network, ".", dns.TypeSOA, false)
+	if err != nil {
+		report.add(name, doctorFail, "no response: %s", err.Error())
+		return false
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		report.add(name, doctorFail, "error response for . SOA: %s", dns.RcodeToString[response.Rcode])
+		return false
+	}
+	if !response.RecursionAvailable {
+		report.add(name, doctorWarn, "responded in %v, but recursion isn't available", rtt)
+		return true
+	}
+	report.add(name, doctorPass, "responded in %v", rtt)
+	return true
+}
+
+// Checks EDNS0 and DNSSEC validation (the AD bit) using the root zone, which is always signed.
+// Returns the root SOA's signature, if there is one.
+func checkEdns(options *options, report *doctorReport, server string, network string) *dns.RRSIG {
+	response, _, err := doctorQuery(options, server, network, ".", dns.TypeSOA, true)
+	if err != nil {
+		report.add("edns0 "+server, doctorFail, "no response to query with EDNS0: %s", err.Error())
+		return nil
+	}
+	opt := response.IsEdns0()
+	if opt == nil || response.Rcode == dns.RcodeFormatError {
+		report.add("edns0 "+server, doctorWarn, "EDNS0 not supported (rcode %s), so large responses need TCP", dns.RcodeToString[response.Rcode])
+		return nil
+	}
+	report.add("edns0 "+server, doctorPass, "EDNS0 supported, UDP size %d", opt.UDPSize())
+
+	if response.AuthenticatedData {
+		report.add("dnssec "+server, doctorPass, "AD bit set for signed data")
+	} else {
+		report.add("dnssec "+server, doctorWarn, "AD bit not set for signed data, so the resolver doesn't validate DNSSEC (or strips the flag)")
+	}
+
+	for _, rr := range response.Answer {
+		if signature, ok := rr.(*dns.RRSIG); ok && signature.TypeCovered == dns.TypeSOA {
+			return signature
+		}
+	}
+	return nil
+}
+
+// DNSSEC validation fails if the clock is outside a signature's validity period.  Root zone signatures are valid for
+// a couple of weeks, so this only catches a badly wrong clock, not the few minutes of skew that break TSIG.
+func checkClock(report *doctorReport, signature *dns.RRSIG, now time.Time) {
+	inception := time.Unix(int64(signature.Inception), 0).UTC()
+	expiration := time.Unix(int64(signature.Expiration), 0).UTC()
+	if !signature.ValidityPeriod(now) {
+		report.add("clock", doctorFail, "local time %s is outside the validity period (%s to %s) of the root zone's signature", now.UTC().Format(time.RFC3339), inception.Format(time.RFC3339), expiration.Format(time.RFC3339))
+		return
+	}
+	report.add("clock", doctorPass, "local time %s is within the validity period (%s to %s) of the root zone's signature (TSIG needs it within %ds, which isn't checked)", now.UTC().Format(time.RFC3339), inception.Format(time.RFC3339), expiration.Format(time.RFC3339), tsigFudge)
+}
+
+// Large TXT sets don't fit in a plain 512 byte UDP response.  Lookups always use TCP, but broken firewalls that
+// block TCP or drop fragmented UDP are common, so check both.
+func checkResponseSize(options *options, report *doctorReport, server string, domain string) {
+	name := "response size " + server
+	tcpResponse, _, err := doctorQuery(options, server, "tcp", domain, dns.TypeTXT, false)
+	if err != nil {
+		report.add(name, doctorFail, "TXT query for %s over TCP failed: %s", domain, err.Error())
+		return
+	}
+	size := tcpResponse.Len()
+
+	udpResponse, _, err := doctorQuery(options, server, "udp", domain, dns.TypeTXT, false)
+	if err != nil {
+		report.add(name, doctorWarn, "TXT query for %s over UDP failed (%s), but TCP works (%d bytes)", domain, err.Error(), size)
+		return
+	}
+	if !udpResponse.Truncated {
+		report.add(name, doctorPass, "TXT set for %s is %d bytes, which fits in a UDP response", domain, size)
+		return
+	}
+	ednsResponse, _, err := doctorQuery(options, server, "udp", domain, dns.TypeTXT, true)
+	if err != nil {
+		report.add(name, doctorWarn, "TXT set for %s is %d bytes: complete over TCP, but UDP with EDNS0 failed (%s), so fragments might be dropped", domain, size, err.Error())
+		return
+	}
+	if ednsResponse.Truncated {
+		report.add(name, doctorWarn, "TXT set for %s is %d bytes: complete over TCP, but truncated over UDP even with EDNS0, so every lookup needs a TCP retry", domain, size)
+		return
+	}
+	report.add(name, doctorPass, "TXT set for %s is %d bytes: truncated over plain UDP, complete over TCP and UDP with EDNS0", domain, size)
+}
+
+// Compares the resolver's view of the TXT set with each of the authoritative servers
+func checkConsistency(options *options, report *doctorReport, resolver string, domain string) {
+	name := "consistency " + domain
+	query := new(dns.Msg)
+	query.SetQuestion(domain, dns.TypeTXT)
+	query.RecursionDesired = true
+	response, _, err := exchange(options, query, resolver, "tcp")
+	if err != nil {
+		report.add(name, doctorFail, "TXT query to %s failed: %s", resolver, err.Error())
+		return
+	}
+	expected := sortedTxtSet(response)
+
+	zone, servers, err := findAuthoritativeServers(options, resolver, domain)
+	if err != nil {
+		report.add(name, doctorWarn, "couldn't find authoritative servers: %s", err.Error())
+		return
+	}
+
+	var mismatches []string
+	for _, server := range servers {
+		query.RecursionDesired = false
+		authoritative, _, err := exchange(options, query, server, "tcp")
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s", server, err.Error()))
+			continue
+		}
+		if got := sortedTxtSet(authoritative); !reflect.DeepEqual(got, expected) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %d records (resolver has %d)", server, len(got), len(expected)))
+		}
+	}
+	if len(mismatches) > 0 {
+		report.add(name, doctorWarn, "TXT set from %s differs from authoritative servers for %s (maybe cached, or still propagating): %s", resolver, zone, strings.Join(mismatches, "; "))
+		return
+	}
+	report.add(name, doctorPass, "TXT set from %s matches all %d authoritative servers for %s", resolver, len(servers), zone)
+}
+
+func sortedTxtSet(response *dns.Msg) []string {
+	records := []string{}
+	for _, rr := range response.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
+		}
+	}
+	sort.Strings(records)
+	return records
+}
+
+func outputDoctorReport(options *options, sink io.Writer, report *doctorReport) error {
+	if options.outputFormat == "json" {
+		encoder := json.NewEncoder(sink)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(report); err != nil {
+			return errors.Wrap(err, "error writing JSON")
+		}
+		return nil
+	}
+	for _, check := range report.Checks {
+		if _, err := fmt.Fprintf(sink, "%-4s  %s: %s\n", strings.ToUpper(check.Status), check.Name, check.Detail); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(sink, "%d passed, %d warnings, %d failed\n", report.Summary[doctorPass], report.Summary[doctorWarn], report.Summary[doctorFail])
+	return err
+}
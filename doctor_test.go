@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func doctorTestZone() string {
+	zone := `
+. 300 IN SOA a.root-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400
+example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 300
+example.com. 300 IN NS ns1.example.com.
+ns1.example.com. 300 IN A 127.0.0.1
+config.example.com. 300 IN TXT "foo=bar"
+`
+	for i := 0; i < 20; i++ {
+		zone += fmt.Sprintf("big.example.com. 300 IN TXT \"key%d=%s\"\n", i, strings.Repeat("x", 100))
+	}
+	return zone
+}
+
+func startDoctorTestServer(t *testing.T) (options *options, shutdown func()) {
+	address, shutdownServer := startTestServer(t, doctorTestZone())
+	_, port, _ := net.SplitHostPort(address)
+	authoritativePort = port
+	options = makeDefaultOptions()
+	options.nameservers = []string{address}
+	return options, func() {
+		shutdownServer()
+		authoritativePort = "53"
+	}
+}
+
+func findCheck(report *doctorReport, prefix string) *doctorCheck {
+	for i := range report.Checks {
+		if strings.HasPrefix(report.Checks[i].Name, prefix) {
+			return &report.Checks[i]
+		}
+	}
+	return nil
+}
+
+type doctorTestPair struct {
+	Check  string
+	Status string
+	Detail string
+}
+
+func TestRunDoctor(t *testing.T) {
+	options, shutdown := startDoctorTestServer(t)
+	defer shutdown()
+
+	for _, domain := range []string{"config.example.com", "big.example.com"} {
+		report := runDoctor(options, domain)
+		if report.failed() {
+			t.Error("Unexpected failure for", domain, report.Checks)
+		}
+		for _, testPair := range []doctorTestPair{
+			{"nameserver", doctorPass, "from --nameserver"},
+			{"udp ", doctorPass, "responded"},
+			{"tcp ", doctorPass, "responded"},
+			{"edns0 ", doctorPass, "UDP size 4096"},
+			{"dnssec ", doctorWarn, "AD bit not set"},
+			{"clock", doctorWarn, "no DNSSEC signatures"},
+			{"consistency ", doctorPass, "matches all 1 authoritative servers for example.com."},
+		} {
+			check := findCheck(report, testPair.Check)
+			if check == nil {
+				t.Error("Missing check", testPair.Check, "for", domain)
+				continue
+			}
+			if check.Status != testPair.Status || !strings.Contains(check.Detail, testPair.Detail) {
+				t.Error("Expected", testPair, "but got", *check, "for", domain)
+			}
+		}
+	}
+
+	report := runDoctor(options, "big.example.com")
+	if check := findCheck(report, "response size "); check == nil || !strings.Contains(check.Detail, "truncated over plain UDP") {
+		t.Error("Expected truncation to be detected but got", check)
+	}
+	report = runDoctor(options, "config.example.com")
+	if check := findCheck(report, "response size "); check == nil || !strings.Contains(check.Detail, "fits in a UDP response") {
+		t.Error("Expected small response to fit but got", check)
+	}
+}
+
+func TestCheckResponseSizeTruncatedWithEDNS0(t *testing.T) {
+	// Bigger than the 4096 bytes that doctor offers with EDNS0
+	zone := doctorTestZone()
+	for i := 0; i < 60; i++ {
+		zone += fmt.Sprintf("huge.example.com. 300 IN TXT \"key%d=%s\"\n", i, strings.Repeat("x", 100))
+	}
+	address, shutdown := startTestServer(t, zone)
+	defer shutdown()
+
+	report := &doctorReport{Summary: map[string]int{}}
+	checkResponseSize(makeDefaultOptions(), report, address, "huge.example.com.")
+	if report.Checks[0].Status != doctorWarn || !strings.Contains(report.Checks[0].Detail, "truncated over UDP even with EDNS0") {
+		t.Error("Expected a warning about truncation with EDNS0 but got", report.Checks[0])
+	}
+}
+
+func TestRunDoctorUnreachable(t *testing.T) {
+	options := makeDefaultOptions()
+	options.nameservers = []string{"127.0.0.1:1"}
+	report := runDoctor(options, "")
+	if !report.failed() {
+		t.Error("Expected failure for unreachable nameserver", report.Checks)
+	}
+	if report.Summary[doctorFail] != 2 {
+		t.Error("Expected UDP and TCP failures but got", report.Checks)
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	signature := &dns.RRSIG{
+		Inception:  uint32(now.Add(-24 * time.Hour).Unix()),
+		Expiration: uint32(now.Add(24 * time.Hour).Unix()),
+	}
+	for _, testPair := range []struct {
+		Now    time.Time
+		Status string
+	}{
+		{now, doctorPass},
+		{now.Add(-48 * time.Hour), doctorFail},
+		{now.Add(48 * time.Hour), doctorFail},
+	} {
+		report := &doctorReport{Summary: map[string]int{}}
+		checkClock(report, signature, testPair.Now)
+		if report.Checks[0].Status != testPair.Status {
+			t.Error("Expected", testPair.Status, "but got", report.Checks[0], "for", testPair.Now)
+		}
+	}
+}
+
+func TestOutputDoctorReport(t *testing.T) {
+	report := &doctorReport{Summary: map[string]int{doctorPass: 0, doctorWarn: 0, doctorFail: 0}}
+	report.add("udp 127.0.0.1:53", doctorPass, "responded in %v", time.Millisecond)
+	report.add("clock", doctorWarn, "not checked")
+
+	var plainBuffer bytes.Buffer
+	if err := outputDoctorReport(makeDefaultOptions(), &plainBuffer, report); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	expected := "PASS  udp 127.0.0.1:53: responded in 1ms\nWARN  clock: not checked\n1 passed, 1 warnings, 0 failed\n"
+	if plainBuffer.String() != expected {
+		t.Error("Expected", expected, "but got", plainBuffer.String())
+	}
+
+	var jsonBuffer bytes.Buffer
+	if err := outputDoctorReport(jsonSingleOptions, &jsonBuffer, report); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	var decoded doctorReport
+	if err := json.Unmarshal(jsonBuffer.Bytes(), &decoded); err != nil {
+		t.Fatal("Error", err.Error(), "decoding", jsonBuffer.String())
+	}
+	if len(decoded.Checks) != 2 || decoded.Checks[1].Status != doctorWarn || decoded.Summary[doctorPass] != 1 {
+		t.Error("Unexpected JSON report", jsonBuffer.String())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+// Output encodings (--encoding), since TXT strings can be arbitrary bytes
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// A JSON value that isn't valid UTF-8, which encoding/json would otherwise silently mangle
+type encodedValue struct {
+	Encoding string `json:"encoding"`
+	Value    string `json:"value"`
+}
+
+func encodeValue(options *options, value string) string {
+	switch options.encoding {
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	case "hex":
+		return hex.EncodeToString([]byte(value))
+	case "c-escape":
+		return cEscape(value)
+	}
+	return value
+}
+
+// Backslash, control characters and invalid UTF-8 are escaped; everything else (including non-ASCII) is kept
+func cEscape(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == '\\':
+			builder.WriteString(`\\`)
+		case r == '\n':
+			builder.WriteString(`\n`)
+		case r == '\r':
+			builder.WriteString(`\r`)
+		case r == '\t':
+			builder.WriteString(`\t`)
+		case r == utf8.RuneError && size == 1, r < 0x20, r == 0x7f:
+			fmt.Fprintf(&builder, `\x%02x`, value[i])
+		default:
+			builder.WriteString(value[i : i+size])
+		}
+		i += size
+	}
+	return builder.String()
+}
+
+// The zero value counts as raw, so options built without --encoding behave like they did before it existed
+func rawEncoding(options *options) bool {
+	return options.encoding == "raw" || options.encoding == ""
+}
+
+// The values to encode as JSON, with raw values that aren't valid UTF-8 as base64 with a marker
+func jsonValues(options *options, values []string) []interface{} {
+	results := make([]interface{}, len(values))
+	for i, value := range values {
+		if rawEncoding(options) && !utf8.ValidString(value) {
+			results[i] = encodedValue{"base64", base64.StdEncoding.EncodeToString([]byte(value))}
+		} else {
+			results[i] = encodeValue(options, value)
+		}
+	}
+	return results
+}
+
+// With --type list, a raw value containing the separator would look like several values
+func checkSeparator(options *options, values []string, separator string, name string) error {
+	if !rawEncoding(options) || options.valueType != "list" {
+		return nil
+	}
+	for _, value := range values {
+		if strings.Contains(value, separator) {
+			return errors.Errorf("a value contains a %s, which separates values (use --encoding c-escape, base64 or hex)", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type encodeValueTestPair struct {
+	Encoding string
+	Value    string
+	Result   string
+}
+
+func TestEncodeValue(t *testing.T) {
+	for _, testPair := range []encodeValueTestPair{
+		{"raw", "a\nb\xff", "a\nb\xff"},
+		{"base64", "a\nb\xff", "YQpi/w=="},
+		{"hex", "a\nb\xff", "610a62ff"},
+		{"c-escape", "plain value", "plain value"},
+		{"c-escape", "a\nb\r\tc", `a\nb\r\tc`},
+		{"c-escape", `C:\temp`, `C:\\temp`},
+		{"c-escape", "caf\u00e9 \"quoted\"", "caf\u00e9 \"quoted\""},
+		{"c-escape", "\xff\x00\x7f\x1b", `\xff\x00\x7f\x1b`},
+		{"c-escape", "", ""},
+	} {
+		options := makeDefaultOptions()
+		options.encoding = testPair.Encoding
+		result := encodeValue(options, testPair.Value)
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+type outputEncodingTestPair struct {
+	Format    string
+	ValueType string
+	Encoding  string
+	Values    []string
+	Result    string
+	Err       error
+}
+
+func TestOutputEncodings(t *testing.T) {
+	for _, testPair := range []outputEncodingTestPair{
+		{"json", "single", "raw", []string{"caf\u00e9"}, "\"caf\u00e9\"\n", nil},
+		{"json", "single", "raw", []string{"a\xffb"}, "{\"encoding\":\"base64\",\"value\":\"Yf9i\"}\n", nil},
+		{"json", "list", "raw", []string{"ok", "a\xffb"}, "[\"ok\",{\"encoding\":\"base64\",\"value\":\"Yf9i\"}]\n", nil},
+		{"json", "list", "hex", []string{"ok", "a\xffb"}, "[\"6f6b\",\"61ff62\"]\n", nil},
+		{"json", "single", "c-escape", []string{"a\xffb"}, "\"a\\\\xffb\"\n", nil},
+		{"plain", "single", "raw", []string{"a\nb"}, "a\nb\n", nil},
+		{"plain", "list", "raw", []string{"a", "b\nc"}, "", errors.New("Separator in value")},
+		{"plain", "list", "c-escape", []string{"a", "b\nc"}, "a\nb\\nc\n", nil},
+		{"plain", "list", "base64", []string{"a", "b\nc"}, "YQ==\nYgpj\n", nil},
+		{"zero", "list", "raw", []string{"a\nb", "c"}, "a\nb\000c\000", nil},
+		{"zero", "list", "raw", []string{"a\000b"}, "", errors.New("Separator in value")},
+		{"zero", "list", "c-escape", []string{"a\000b"}, "a\\x00b\000", nil},
+		{"json", "single", "", []string{"a\xffb"}, "{\"encoding\":\"base64\",\"value\":\"Yf9i\"}\n", nil},
+		{"plain", "list", "", []string{"a", "b\nc"}, "", errors.New("Separator in value")},
+	} {
+		options := makeDefaultOptions()
+		options.outputFormat = testPair.Format
+		options.valueType = testPair.ValueType
+		options.encoding = testPair.Encoding
+		var outBuffer bytes.Buffer
+		err := output(options, &outBuffer, testPair.Values)
+		result := outBuffer.String()
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
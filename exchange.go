@@ -0,0 +1,207 @@
+package main
+
+// Sending queries to nameservers: one at a time with failover, or racing several at once (--race)
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Same as the miekg/dns client defaults
+const exchangeTimeout = 2 * time.Second
+
+// Delay between starting each query when racing nameservers (overridden in tests)
+var raceStagger = 100 * time.Millisecond
+
+// Sends a query to a nameserver over "tcp" or "udp", tracing the messages
+func exchange(options *options, query *dns.Msg, nameserver string, network string) (*dns.Msg, time.Duration, error) {
+	return exchangeContext(context.Background(), options, query, nameserver, network)
+}
+
+// Like exchange, but gives up as soon as ctx is cancelled.  Every query goes through here, so this is also where
+// sessions are recorded and replayed (see session.go).
+func exchangeContext(ctx context.Context, options *options, query *dns.Msg, nameserver string, network string) (*dns.Msg, time.Duration, error) {
+	traceQuery(options, nameserver, query)
+	if options.session != nil && options.session.replaying() {
+		return options.session.replay(options, query, nameserver, network)
+	}
+	response, rtt, err := exchangeConn(ctx, options, query, nameserver, network)
+	// Cancelled queries (e.g., losing a --race) say nothing about the nameserver
+	if options.session != nil && ctx.Err() == nil {
+		if recordErr := options.session.record(query, nameserver, network, response, rtt, err); recordErr != nil {
+			warnf(options, "%s", recordErr.Error())
+		}
+	}
+	return response, rtt, err
+}
+
+// (The miekg/dns client only uses a context for its deadline, so we manage the connection ourselves.)
+func exchangeConn(ctx context.Context, options *options, query *dns.Msg, nameserver string, network string) (*dns.Msg, time.Duration, error) {
+	dialer := &net.Dialer{Timeout: queryTimeout(options)}
+	rawConn, err := dialer.DialContext(ctx, network, nameserver)
+	if err != nil {
+		return nil, 0, err
+	}
+	conn := &dns.Conn{Conn: rawConn}
+	if opt := query.IsEdns0(); opt != nil && opt.UDPSize() >= dns.MinMsgSize {
+		conn.UDPSize = opt.UDPSize()
+	}
+	signed := query.IsTsig() != nil && options.tsig != nil
+	if signed {
+		conn.TsigSecret = map[string]string{options.tsig.name: options.tsig.secret}
+		// Signing takes the TSIG record out of the message, which would stop it being signed for the next nameserver
+		query = query.Copy()
+	}
+	defer conn.Close()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	start := time.Now()
+	conn.SetDeadline(start.Add(queryTimeout(options)))
+	if err = conn.WriteMsg(query); err == nil {
+		var response *dns.Msg
+		response, err = conn.ReadMsg()
+		if signed && err != nil && response != nil {
+			err = errors.Wrapf(err, "TSIG verification with key %s failed", options.tsig.name)
+		} else if signed && err == nil && response.IsTsig() == nil {
+			err = errors.Errorf("response isn't signed with TSIG key %s", options.tsig.name)
+		}
+		if err == nil && response.Id != query.Id {
+			err = dns.ErrId
+		}
+		if err == nil {
+			rtt := time.Since(start)
+			traceResponse(options, nameserver, response, rtt)
+			return response, rtt, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+	return nil, 0, err
+}
+
+// How long each query can take
+func queryTimeout(options *options) time.Duration {
+	if options.queryTimeout > 0 {
+		return options.queryTimeout
+	}
+	return exchangeTimeout
+}
+
+// Server failures mean another nameserver might do better
+func usableResponse(nameserver string, response *dns.Msg) error {
+	switch response.Rcode {
+	case dns.RcodeServerFailure, dns.RcodeRefused:
+		return errors.Errorf("error from remote DNS server %s: %s", nameserver, dns.RcodeToString[response.Rcode])
+	}
+	return nil
+}
+
+// Returns the first response that passes the usable check, trying nameservers in order, or racing up to --race of them
+func exchangeAny(options *options, query *dns.Msg, nameservers []string, network string, usable func(nameserver string, response *dns.Msg) error) (*dns.Msg, error) {
+	if options.race > 1 && len(nameservers) > 1 {
+		return raceExchange(options, query, nameservers, network, usable)
+	}
+	var err error
+	for _, nameserver := range nameservers {
+		var response *dns.Msg
+		response, _, err = exchange(options, query, nameserver, network)
+		if err != nil {
+			err = errors.Wrap(err, "error executing DNS query")
+		} else if err = usable(nameserver, response); err == nil {
+			return response, nil
+		}
+		tracef(options, traceSummary, "nameserver %s failed: %s", nameserver, err.Error())
+	}
+	return nil, err
+}
+
+type raceResult struct {
+	nameserver string
+	response   *dns.Msg
+	err        error
+}
+
+// Happy-eyeballs style: start a query to each nameserver in turn, raceStagger apart, with at most --race of them
+// outstanding, and take the first usable response.  A failure starts the next query straight away, so every
+// nameserver still gets tried.  Outstanding queries are cancelled once there's a winner.
+func raceExchange(options *options, query *dns.Msg, nameservers []string, network string, usable func(nameserver string, response *dns.Msg) error) (*dns.Msg, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan raceResult, len(nameservers))
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	started, finished := 0, 0
+	start := func() {
+		nameserver := nameservers[started]
+		started++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, _, err := exchangeContext(ctx, options, query.Copy(), nameserver, network)
+			if err != nil {
+				err = errors.Wrap(err, "error executing DNS query")
+			} else {
+				err = usable(nameserver, response)
+			}
+			results <- raceResult{nameserver, response, err}
+		}()
+	}
+	canStart := func() bool {
+		return started < len(nameservers) && started-finished < options.race
+	}
+
+	start()
+	stagger := time.NewTimer(raceStagger)
+	defer stagger.Stop()
+	// The timer might have fired without its tick being read, which would start the next query early
+	resetStagger := func() {
+		if !stagger.Stop() {
+			select {
+			case <-stagger.C:
+			default:
+			}
+		}
+		stagger.Reset(raceStagger)
+	}
+	var lastErr error
+	for finished < len(nameservers) {
+		select {
+		case <-stagger.C:
+			if canStart() {
+				start()
+				stagger.Reset(raceStagger)
+			}
+		case result := <-results:
+			finished++
+			if result.err == nil {
+				tracef(options, traceSummary, "race won by nameserver %s", result.nameserver)
+				return result.response, nil
+			}
+			tracef(options, traceSummary, "nameserver %s failed: %s", result.nameserver, result.err.Error())
+			lastErr = result.err
+			if canStart() {
+				start()
+				resetStagger()
+			}
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const exchangeTestZone = `
+config.example.com. 300 IN TXT "foo=bar"
+`
+
+func startDelayedTestServer(t *testing.T, delay time.Duration, rcode int) (address string, shutdown func()) {
+	return startTestServerWith(t, exchangeTestZone, func(response *dns.Msg) {
+		time.Sleep(delay)
+		if rcode != dns.RcodeSuccess {
+			response.Answer = nil
+			response.Rcode = rcode
+		}
+	})
+}
+
+type exchangeTestServer struct {
+	Delay time.Duration
+	Rcode int
+}
+
+type exchangeTestPair struct {
+	Race    int
+	Servers []exchangeTestServer
+	Winner  int // Index of the server that should answer, or -1 for an error
+	MaxTime time.Duration
+}
+
+func TestExchangeAny(t *testing.T) {
+	defer func(stagger time.Duration) { raceStagger = stagger }(raceStagger)
+	raceStagger = 20 * time.Millisecond
+
+	slow := 500 * time.Millisecond
+	for _, testPair := range []exchangeTestPair{
+		{1, []exchangeTestServer{{0, dns.RcodeSuccess}, {0, dns.RcodeSuccess}}, 0, slow},
+		{1, []exchangeTestServer{{0, dns.RcodeServerFailure}, {0, dns.RcodeSuccess}}, 1, slow},
+		{1, []exchangeTestServer{{0, dns.RcodeRefused}, {0, dns.RcodeServerFailure}}, -1, slow},
+		{1, []exchangeTestServer{{slow, dns.RcodeSuccess}, {0, dns.RcodeSuccess}}, 0, 2 * slow},
+		{2, []exchangeTestServer{{slow, dns.RcodeSuccess}, {0, dns.RcodeSuccess}}, 1, slow / 2},
+		{2, []exchangeTestServer{{0, dns.RcodeServerFailure}, {50 * time.Millisecond, dns.RcodeSuccess}}, 1, slow / 2},
+		{3, []exchangeTestServer{{slow, dns.RcodeSuccess}, {slow, dns.RcodeSuccess}, {0, dns.RcodeSuccess}}, 2, slow / 2},
+		{2, []exchangeTestServer{{slow, dns.RcodeSuccess}, {slow, dns.RcodeSuccess}, {0, dns.RcodeSuccess}}, 0, 2 * slow},
+		{3, []exchangeTestServer{{0, dns.RcodeServerFailure}, {0, dns.RcodeServerFailure}, {0, dns.RcodeServerFailure}}, -1, slow},
+		{2, []exchangeTestServer{{0, dns.RcodeServerFailure}, {0, dns.RcodeRefused}, {0, dns.RcodeServerFailure}, {0, dns.RcodeSuccess}}, 3, slow},
+	} {
+		var nameservers []string
+		for _, server := range testPair.Servers {
+			address, shutdown := startDelayedTestServer(t, server.Delay, server.Rcode)
+			defer shutdown()
+			nameservers = append(nameservers, address)
+		}
+
+		var traceBuffer bytes.Buffer
+		options := makeDefaultOptions()
+		options.race = testPair.Race
+		options.verbosity = traceSummary
+		options.traceSink = &traceBuffer
+
+		query := new(dns.Msg)
+		query.SetQuestion("config.example.com.", dns.TypeTXT)
+		start := time.Now()
+		var winner string
+		var winnerLock sync.Mutex
+		response, err := exchangeAny(options, query, nameservers, "tcp", func(nameserver string, response *dns.Msg) error {
+			err := usableResponse(nameserver, response)
+			if err == nil {
+				winnerLock.Lock()
+				defer winnerLock.Unlock()
+				if winner == "" {
+					winner = nameserver
+				}
+			}
+			return err
+		})
+		elapsed := time.Since(start)
+
+		if testPair.Winner < 0 {
+			if err == nil {
+				t.Error("Expected error not caught for", testPair)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+			continue
+		}
+		if len(response.Answer) != 1 {
+			t.Error("Expected 1 answer but got", response.Answer, "for", testPair)
+		}
+		if testPair.Race > 1 && !strings.Contains(traceBuffer.String(), "race won by nameserver "+nameservers[testPair.Winner]) {
+			t.Error("Expected", nameservers[testPair.Winner], "to win but got trace:\n", traceBuffer.String(), "for", testPair)
+		}
+		if winner != nameservers[testPair.Winner] {
+			t.Error("Expected answer from", nameservers[testPair.Winner], "but got", winner, "for", testPair)
+		}
+		// Outstanding queries are cancelled, so a slow loser doesn't hold things up
+		if elapsed > testPair.MaxTime {
+			t.Error("Expected answer within", testPair.MaxTime, "but took", elapsed, "for", testPair)
+		}
+	}
+}
+
+func TestNameserverFailover(t *testing.T) {
+	address, shutdown := startTestServer(t, exchangeTestZone)
+	defer shutdown()
+	options := makeDefaultOptions()
+	options.nameservers = []string{"127.0.0.1:1", address}
+
+	provider, err := makeDnsProvider(options, "", "config.example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	records, err := provider.getTxtRecords()
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(records, []string{"foo=bar"}) {
+		t.Error("Expected [foo=bar] but got", records)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+// sdget flag: evaluates feature flag rules stored as values
+//
+// Rules:
+// * on, off (or true, false)
+// * N%: on for a stable N% of subjects (e.g., 25% or 2.5%)
+// * hosts:a,b,c: on for the listed subjects only
+// * after:2026-11-01 (or an RFC 3339 time, see parseTime()): on from that time onwards
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Percentages are resolved to 0.01%
+const flagBuckets = 10000
+
+func evaluateFlag(options *options, rule string, name string, subject string, now time.Time) (bool, error) {
+	rule = strings.TrimSpace(rule)
+	switch strings.ToLower(rule) {
+	case "on", "true":
+		return true, nil
+	case "off", "false":
+		return false, nil
+	}
+
+	if strings.HasSuffix(rule, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(rule, "%")), 64)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return false, errors.Errorf("invalid percentage in flag rule \"%s\"", rule)
+		}
+		if subject == "" {
+			return false, errors.Errorf("flag rule \"%s\" needs a subject", rule)
+		}
+		return float64(flagBucket(options, name, subject)) < percentage*flagBuckets/100, nil
+	}
+
+	i := strings.IndexByte(rule, ':')
+	if i < 0 {
+		return false, errors.Errorf("unrecognised flag rule \"%s\"", rule)
+	}
+	argument := strings.TrimSpace(rule[i+1:])
+	switch strings.ToLower(strings.TrimSpace(rule[:i])) {
+	case "hosts":
+		if subject == "" {
+			return false, errors.Errorf("flag rule \"%s\" needs a subject", rule)
+		}
+		for _, host := range strings.Split(argument, ",") {
+			if normalizeKey(options, strings.TrimSpace(host)) == normalizeKey(options, subject) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "after":
+		start, err := parseTime(argument)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid flag rule \"%s\"", rule)
+		}
+		return !now.Before(start), nil
+	}
+	return false, errors.Errorf("unrecognised flag rule \"%s\"", rule)
+}
+
+// Maps a subject to one of flagBuckets buckets.  The flag name is hashed in too, so that different flags at the same
+// percentage aren't all on for the same subjects.  Subjects are normalized like in hosts: rules, so Web01 and web01
+// get the same bucket.
+func flagBucket(options *options, name string, subject string) uint64 {
+	sum := sha256.Sum256([]byte(normalizeKey(options, name) + "\000" + normalizeKey(options, subject)))
+	return binary.BigEndian.Uint64(sum[:8]) % flagBuckets
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type evaluateFlagTestPair struct {
+	Rule    string
+	Subject string
+	Result  bool
+	Err     error
+}
+
+func TestEvaluateFlag(t *testing.T) {
+	options := makeDefaultOptions()
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	for _, testPair := range []evaluateFlagTestPair{
+		{"on", "a", true, nil},
+		{" ON ", "", true, nil},
+		{"true", "a", true, nil},
+		{"off", "a", false, nil},
+		{"False", "a", false, nil},
+		{"100%", "a", true, nil},
+		{"0%", "a", false, nil},
+		{"0.0%", "b", false, nil},
+		{"25%", "", false, errors.New("No subject")},
+		{"101%", "a", false, errors.New("Bad percentage")},
+		{"-1%", "a", false, errors.New("Bad percentage")},
+		{"x%", "a", false, errors.New("Bad percentage")},
+		{"hosts:web1,web2", "web2", true, nil},
+		{"hosts: web1 , WEB2 ", "web2", true, nil},
+		{"HOSTS:web1,web2", "web3", false, nil},
+		{"hosts:web1", "", false, errors.New("No subject")},
+		{"after:2026-10-15", "", true, nil},
+		{"after:2026-10-16", "", false, nil},
+		{"after:2026-10-15T12:00:00Z", "", true, nil},
+		{"after:2026-10-15T13:00:00+02:00", "", true, nil},
+		{"after:2026-10-15T12:00:01Z", "", false, nil},
+		{"after:tomorrow", "", false, errors.New("Bad time")},
+		{"maybe", "a", false, errors.New("Unrecognised")},
+		{"before:2026-10-15", "a", false, errors.New("Unrecognised")},
+		{"", "a", false, errors.New("Unrecognised")},
+	} {
+		result, err := evaluateFlag(options, testPair.Rule, "feature.x", testPair.Subject, now)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestFlagPercentage(t *testing.T) {
+	options := makeDefaultOptions()
+	now := time.Now()
+	for _, percentage := range []int{10, 25, 50} {
+		enabled := 0
+		for i := 0; i < 10000; i++ {
+			subject := fmt.Sprintf("host%d", i)
+			result, err := evaluateFlag(options, fmt.Sprintf("%d%%", percentage), "feature.x", subject, now)
+			if err != nil {
+				t.Fatal("Error", err.Error())
+			}
+			// Stable for the same subject and flag
+			again, _ := evaluateFlag(options, fmt.Sprintf("%d%%", percentage), "FEATURE.X", strings.ToUpper(subject), now)
+			if again != result {
+				t.Error("Expected stable result for", subject)
+			}
+			// Raising the percentage only adds subjects
+			if bigger, _ := evaluateFlag(options, fmt.Sprintf("%d%%", percentage+10), "feature.x", subject, now); result && !bigger {
+				t.Error("Expected", subject, "to stay enabled when raising", percentage, "%")
+			}
+			if result {
+				enabled++
+			}
+		}
+		if enabled < percentage*100-300 || enabled > percentage*100+300 {
+			t.Error("Expected about", percentage*100, "of 10000 subjects enabled but got", enabled)
+		}
+	}
+}
+
+func TestFlagBucketDependsOnName(t *testing.T) {
+	options := makeDefaultOptions()
+	same := 0
+	for i := 0; i < 100; i++ {
+		subject := fmt.Sprintf("host%d", i)
+		if flagBucket(options, "feature.x", subject) == flagBucket(options, "feature.y", subject) {
+			same++
+		}
+	}
+	if same > 5 {
+		t.Error("Expected different flags to bucket subjects differently, but", same, "of 100 matched")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+// _include records: pulling in another source's table, like SPF's include: mechanism
+//
+// "_include=common.example.com" makes keys that aren't in the local table get looked up in common.example.com (which
+// can have its own _include records).  Local records always take precedence, then included sources in the order
+// they're listed, depth-first.  Only file sources can include file sources, so that DNS data can't get local files
+// read.
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const includeKey = "_include"
+
+// Same limit as SPF (https://tools.ietf.org/html/rfc7208#section-4.6.4)
+const maxIncludeLookups = 10
+
+// Fetches the records of source that could match key (replaced in tests)
+var fetchSource = func(options *options, source string, key string) ([]string, error) {
+	provider, err := getTxtProvider(options, source)
+	if err != nil {
+		return nil, err
+	}
+	return getKeyTxtRecords(provider, key)
+}
+
+type includeState struct {
+	stack   []string // Sources currently being included, for finding loops
+	lookups int
+}
+
+func makeIncludeState() *includeState {
+	return &includeState{}
+}
+
+func includeID(source string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(source), "."))
+}
+
+func (s *includeState) findIncludedValues(options *options, txtRecords []string, key string) ([]string, error) {
+	sources, err := findValues(options, txtRecords, includeKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading _include records")
+	}
+	for _, source := range sources {
+		id := includeID(source)
+		for _, ancestor := range s.stack {
+			if ancestor == id {
+				return nil, errors.Errorf("_include loop (%s -> %s)", strings.Join(s.stack, " -> "), id)
+			}
+		}
+		if isFileSource(options, source) && !options.fileSource {
+			return nil, errors.Errorf("_include %s is a file, and only files can include files", strings.TrimSpace(source))
+		}
+		if s.lookups >= maxIncludeLookups {
+			return nil, errors.Errorf("more than %d _include lookups", maxIncludeLookups)
+		}
+		s.lookups++
+
+		tracef(options, traceSummary, "looking for key %q in _include %s", key, source)
+		records, err := fetchSource(options, strings.TrimSpace(source), key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error fetching _include %s", source)
+		}
+		values, err := findValues(options, records, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) > 0 {
+			return values, nil
+		}
+
+		includedOptions := *options
+		includedOptions.fileSource = isFileSource(options, source)
+		s.stack = append(s.stack, id)
+		values, err = s.findIncludedValues(&includedOptions, records, key)
+		s.stack = s.stack[:len(s.stack)-1]
+		if err != nil || len(values) > 0 {
+			return values, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var includeTestSources = map[string][]string{
+	"app.example.com": {
+		"_include=common.example.com",
+		"_include=other.example.com",
+		"name=app",
+		"timeout=60",
+	},
+	"common.example.com": {
+		"_include=base.example.com",
+		"timeout=30",
+		"region=eu",
+		"list=1",
+		"list=2",
+	},
+	"other.example.com": {
+		"region=us",
+		"owner=ops",
+	},
+	"base.example.com": {
+		"owner=platform",
+		"loglevel=info",
+	},
+	"loop1.example.com": {
+		"_include=loop2.example.com",
+	},
+	"loop2.example.com": {
+		"_include=LOOP1.example.com.",
+	},
+	"broken.example.com": {
+		"_include=nosuchsource.example.com",
+	},
+	"secrets.example.com": {
+		"_include=file:///home/user/.env",
+	},
+	"indirect.example.com": {
+		"_include=file:///home/user/.env",
+	},
+	"file:///etc/sdget/app": {
+		"_include=file:///etc/sdget/common",
+		"_include=base.example.com",
+	},
+	"file:///etc/sdget/common": {
+		"owner=files",
+		"_include=indirect.example.com",
+	},
+	"file:///home/user/.env": {
+		"token=hunter2",
+	},
+}
+
+func init() {
+	// Chains of includes for testing the lookup limit
+	for i := 0; i < 12; i++ {
+		includeTestSources["chain"+string('a'+rune(i))+".example.com"] = []string{"_include=chain" + string('a'+rune(i+1)) + ".example.com"}
+	}
+	includeTestSources["chainm.example.com"] = []string{"end=reached"}
+	includeTestSources["chaini.example.com"] = append(includeTestSources["chaini.example.com"], "nearend=reached")
+}
+
+func useIncludeTestSources(fetches *[]string) func() {
+	original := fetchSource
+	fetchSource = func(options *options, source string, key string) ([]string, error) {
+		*fetches = append(*fetches, source)
+		records, ok := includeTestSources[source]
+		if !ok {
+			return nil, errors.New("no such source")
+		}
+		return records, nil
+	}
+	return func() { fetchSource = original }
+}
+
+type includeTestPair struct {
+	Source  string
+	Key     string
+	Result  []string
+	Fetches string
+	Err     error
+}
+
+func TestLookUpIncludedValues(t *testing.T) {
+	for _, testPair := range []includeTestPair{
+		{"app.example.com", "name", []string{"app"}, "", nil},
+		{"app.example.com", "timeout", []string{"60"}, "", nil},
+		{"app.example.com", "region", []string{"eu"}, "common.example.com", nil},
+		{"app.example.com", "list", []string{"1", "2"}, "common.example.com", nil},
+		{"app.example.com", "owner", []string{"platform"}, "common.example.com base.example.com", nil},
+		{"app.example.com", "loglevel", []string{"info"}, "common.example.com base.example.com", nil},
+		{"app.example.com", "nosuchkey", []string{}, "common.example.com base.example.com other.example.com", nil},
+		{"loop1.example.com", "key", nil, "", errors.New("Loop")},
+		{"broken.example.com", "key", nil, "", errors.New("Missing source")},
+		{"chaina.example.com", "nearend", []string{"reached"}, "", nil},
+		{"chaina.example.com", "end", nil, "", errors.New("Too many lookups")},
+		{"secrets.example.com", "token", nil, "", errors.New("File from DNS")},
+		{"file:///etc/sdget/app", "owner", []string{"files"}, "file:///etc/sdget/common", nil},
+		{"file:///etc/sdget/app", "loglevel", nil, "", errors.New("File from DNS via a file")},
+	} {
+		var fetches []string
+		restore := useIncludeTestSources(&fetches)
+		options := makeDefaultOptions()
+		options.valueType = "list"
+		options.fileSource = isFileSource(options, testPair.Source)
+		result, err := lookUpValues(options, includeTestSources[testPair.Source], testPair.Key, []string{})
+		restore()
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+
+		if testPair.Fetches != "" && strings.Join(fetches, " ") != testPair.Fetches {
+			t.Error("Expected fetches", testPair.Fetches, "but got", fetches, "for", testPair)
+		}
+	}
+}
+
+func TestIncludeUsesDefaultsLast(t *testing.T) {
+	var fetches []string
+	defer useIncludeTestSources(&fetches)()
+	values, err := lookUpValues(makeDefaultOptions(), includeTestSources["app.example.com"], "owner", []string{"nobody"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(values, []string{"platform"}) {
+		t.Error("Expected [platform] but got", values)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+// --interpolate: expanding ${key} and ${source#key} references in values
+//
+// ${key} refers to the same source as the value it's in, and ${source#key} to any other source (a domain name or
+// URI).  Only values from file sources can refer to file sources.  $$ is a literal $.  Referenced values are expanded
+// too, so references can be nested.  Each source is only fetched once.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Limits chains like ${a} -> ${b} -> ${c} ...
+const maxInterpolationDepth = 10
+
+// A reference that couldn't be expanded, because of a missing key or source, a cycle, or too much nesting
+type unresolvedReferenceError struct {
+	reference string
+	err       error
+}
+
+func (e *unresolvedReferenceError) Error() string {
+	return fmt.Sprintf("unresolved reference ${%s}: %s", e.reference, e.err.Error())
+}
+
+// Expands references in the values of key, which were looked up in txtRecords
+type valueExpander func(key string, txtRecords []string, values []string) ([]string, error)
+
+type interpolator struct {
+	options *options
+	cache   map[string][]string
+	// Fetches the records of source that could match key (replaceable in tests)
+	fetch func(source string, key string) ([]string, error)
+}
+
+func makeInterpolator(options *options) *interpolator {
+	return &interpolator{
+		options: options,
+		cache:   make(map[string][]string),
+		fetch: func(source string, key string) ([]string, error) {
+			return fetchSource(options, source, key)
+		},
+	}
+}
+
+// With --interpolate, makes lookUpValues expand references in the values it finds in source
+func setUpInterpolation(options *options, source string) {
+	if !options.interpolate {
+		return
+	}
+	i := makeInterpolator(options)
+	options.expandValues = func(key string, txtRecords []string, values []string) ([]string, error) {
+		return i.expandValues(source, key, txtRecords, values)
+	}
+}
+
+// With --layout per-name or hashed, each key of a source is fetched separately
+func (i *interpolator) cacheKey(source string, key string) string {
+	if perKeyLayout(i.options) {
+		return source + "#" + normalizeKey(i.options, key)
+	}
+	return source
+}
+
+func (i *interpolator) records(source string, key string) ([]string, error) {
+	cacheKey := i.cacheKey(source, key)
+	if records, ok := i.cache[cacheKey]; ok {
+		return records, nil
+	}
+	records, err := i.fetch(source, key)
+	if err != nil {
+		return nil, err
+	}
+	i.cache[cacheKey] = records
+	return records, nil
+}
+
+// Expands the values of key, which were looked up in records from source
+func (i *interpolator) expandValues(source string, key string, records []string, values []string) ([]string, error) {
+	i.cache[i.cacheKey(source, key)] = records
+	stack := []string{source + "#" + normalizeKey(i.options, key)}
+	var results []string
+	for _, value := range values {
+		expanded, err := i.expand(source, value, stack)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, expanded)
+	}
+	return results, nil
+}
+
+// stack holds the references being expanded, for finding cycles
+func (i *interpolator) expand(source string, value string, stack []string) (string, error) {
+	var result strings.Builder
+	for {
+		start := strings.IndexByte(value, '$')
+		if start < 0 || start == len(value)-1 {
+			result.WriteString(value)
+			return result.String(), nil
+		}
+		result.WriteString(value[:start])
+		switch value[start+1] {
+		case '$':
+			result.WriteByte('$')
+			value = value[start+2:]
+			continue
+		case '{':
+		default:
+			result.WriteByte('$')
+			value = value[start+1:]
+			continue
+		}
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			return "", errors.Errorf("unterminated reference in \"%s\"", value[start:])
+		}
+		reference := value[start+2 : start+end]
+		expanded, err := i.resolve(source, reference, stack)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(expanded)
+		value = value[start+end+1:]
+	}
+}
+
+func (i *interpolator) resolve(source string, reference string, stack []string) (string, error) {
+	refSource, refKey := source, reference
+	if hash := strings.LastIndexByte(reference, '#'); hash >= 0 {
+		refSource, refKey = reference[:hash], reference[hash+1:]
+	}
+	id := refSource + "#" + normalizeKey(i.options, refKey)
+	for _, ancestor := range stack {
+		if ancestor == id {
+			return "", &unresolvedReferenceError{reference, errors.Errorf("reference cycle (%s -> %s)", strings.Join(stack, " -> "), id)}
+		}
+	}
+	if len(stack) > maxInterpolationDepth {
+		return "", &unresolvedReferenceError{reference, errors.Errorf("references nested more than %d deep", maxInterpolationDepth)}
+	}
+	if refSource != source && isFileSource(i.options, refSource) && !isFileSource(i.options, source) {
+		return "", &unresolvedReferenceError{reference, errors.Errorf("only values from files can refer to files, and this is from %s", source)}
+	}
+
+	records, err := i.records(refSource, refKey)
+	if err != nil {
+		return "", &unresolvedReferenceError{reference, err}
+	}
+	// References always need exactly one value, as stored
+	singleOptions := *i.options
+	singleOptions.valueType = "single"
+	singleOptions.transforms = nil
+	singleOptions.fileSource = isFileSource(i.options, refSource)
+	// The referenced value is expanded below, as part of this reference
+	singleOptions.expandValues = nil
+	if refSource != source {
+		// The schema is for the table being looked up
+		singleOptions.schema = nil
+	}
+	values, err := lookUpValues(&singleOptions, records, refKey, nil)
+	if err != nil {
+		return "", &unresolvedReferenceError{reference, err}
+	}
+	expanded, err := i.expand(refSource, values[0], append(stack[:len(stack):len(stack)], id))
+	if err != nil {
+		return "", err
+	}
+	tracef(i.options, traceSummary, "expanded ${%s} to %q", reference, redactValue(i.options, refKey, expanded))
+	return expanded, nil
+}
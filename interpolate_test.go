@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var interpolateTestSources = map[string][]string{
+	"config.example.com": {
+		"host=api.example.com",
+		"port=8443",
+		"url=https://${host}:${port}/api",
+		"nested=${url}?v=1",
+		"price=$$5 or $${notareference}",
+		"dollar=$5 and $",
+		"remote=${common.example.com#proxy}",
+		"remotenested=${common.example.com#proxyurl}",
+		"cycle=${cycle2}",
+		"cycle2=${cycle}",
+		"self=${self}",
+		"missing=${nosuchkey}",
+		"missingsource=${nosuchsource.example.com#key}",
+		"file=${file:///tmp/ports#proxyport}",
+		"multi=${list}",
+		"list=1",
+		"list=2",
+		"unterminated=${host",
+		"deep=${d1}",
+		"d1=${d2}", "d2=${d3}", "d3=${d4}", "d4=${d5}", "d5=${d6}", "d6=${d7}",
+		"d7=${d8}", "d8=${d9}", "d9=${d10}", "d10=${d11}", "d11=${d12}", "d12=end",
+		"notdeep=${d3}",
+		"hosts=api1.example.com,api2.example.com",
+		"allhosts=${hosts}",
+	},
+	"common.example.com": {
+		"proxy=proxy.example.com",
+		"proxyurl=http://${proxy}:${dns://ns.example.com/ports.example.com#proxyport}",
+		"host=wrong.example.com",
+		"proxyfile=${file:///tmp/ports#proxyport}",
+	},
+	"dns://ns.example.com/ports.example.com": {
+		"proxyport=3128",
+	},
+	"file:///tmp/config": {
+		"proxyport=${file:///tmp/ports#proxyport}",
+		"proxy=${common.example.com#proxyfile}",
+	},
+	"file:///tmp/ports": {
+		"proxyport=3128",
+	},
+}
+
+func makeTestInterpolator(fetches map[string]int) *interpolator {
+	i := makeInterpolator(makeDefaultOptions())
+	i.fetch = func(source string, key string) ([]string, error) {
+		fetches[source]++
+		records, ok := interpolateTestSources[source]
+		if !ok {
+			return nil, errors.New("no such source")
+		}
+		return records, nil
+	}
+	return i
+}
+
+type interpolateTestPair struct {
+	Key        string
+	Result     []string
+	Unresolved bool
+}
+
+func TestInterpolate(t *testing.T) {
+	options := makeDefaultOptions()
+	records := interpolateTestSources["config.example.com"]
+	for _, testPair := range []interpolateTestPair{
+		{"host", []string{"api.example.com"}, false},
+		{"url", []string{"https://api.example.com:8443/api"}, false},
+		{"nested", []string{"https://api.example.com:8443/api?v=1"}, false},
+		{"price", []string{"$5 or ${notareference}"}, false},
+		{"dollar", []string{"$5 and $"}, false},
+		{"remote", []string{"proxy.example.com"}, false},
+		{"remotenested", []string{"http://proxy.example.com:3128"}, false},
+		{"notdeep", []string{"end"}, false},
+		{"cycle", nil, true},
+		{"self", nil, true},
+		{"missing", nil, true},
+		{"missingsource", nil, true},
+		{"file", nil, true},
+		{"multi", nil, true},
+		{"deep", nil, true},
+		{"unterminated", nil, false},
+	} {
+		values, err := lookUpValues(options, records, testPair.Key, nil)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		result, err := makeTestInterpolator(make(map[string]int)).expandValues("config.example.com", testPair.Key, records, values)
+
+		if err == nil && testPair.Result == nil {
+			t.Error("Expected error not caught for", testPair)
+		}
+		if err != nil && testPair.Result != nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+		if _, ok := err.(*unresolvedReferenceError); ok != testPair.Unresolved {
+			t.Error("Expected unresolved reference error", testPair.Unresolved, "but got", err, "for", testPair)
+		}
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestInterpolateFetchesSourcesOnce(t *testing.T) {
+	fetches := make(map[string]int)
+	i := makeTestInterpolator(fetches)
+	records := []string{"a=${common.example.com#proxy} ${common.example.com#proxyurl} ${b}", "b=${common.example.com#proxy}"}
+	values, err := i.expandValues("config.example.com", "a", records, []string{"${common.example.com#proxy} ${common.example.com#proxyurl} ${b}"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(values, []string{"proxy.example.com http://proxy.example.com:3128 proxy.example.com"}) {
+		t.Error("Unexpected result", values)
+	}
+	if !reflect.DeepEqual(fetches, map[string]int{"common.example.com": 1, "dns://ns.example.com/ports.example.com": 1}) {
+		t.Error("Expected each source fetched once but got", fetches)
+	}
+}
+
+func TestInterpolateFileReferences(t *testing.T) {
+	options := makeDefaultOptions()
+	options.aliases = map[string]string{"ports": "file:///tmp/ports"}
+	for _, testPair := range []struct {
+		Source string
+		Value  string
+		Result []string
+	}{
+		{"file:///tmp/config", "${file:///tmp/ports#proxyport}", []string{"3128"}},
+		{"file:///tmp/config", "${proxyport}", []string{"3128"}},
+		{"file:///tmp/config", "${common.example.com#proxyfile}", nil},
+		{"config.example.com", "${file:///tmp/ports#proxyport}", nil},
+		{"config.example.com", "${@ports#proxyport}", nil},
+		{"@ports", "${proxyport}", []string{"3128"}},
+	} {
+		i := makeTestInterpolator(make(map[string]int))
+		i.options = options
+		records, ok := interpolateTestSources[testPair.Source]
+		if !ok {
+			records = interpolateTestSources["file:///tmp/ports"]
+		}
+		result, err := i.expandValues(testPair.Source, "key", records, []string{testPair.Value})
+		if err == nil && testPair.Result == nil {
+			t.Error("Expected error not caught for", testPair)
+		}
+		if err != nil && testPair.Result != nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestInterpolateBeforeSchema(t *testing.T) {
+	records := interpolateTestSources["config.example.com"]
+	for _, testPair := range []struct {
+		Schema string
+		Key    string
+		Result []string
+	}{
+		{`{"keys": {"url": {"pattern": "^https://[a-z.]+:[0-9]+/api$"}}}`, "url", []string{"https://api.example.com:8443/api"}},
+		// References into the same table are checked against its schema too
+		{`{"keys": {"port": {"type": "integer", "maximum": 1024}}}`, "url", nil},
+		{`{"keys": {"port": {"type": "integer", "maximum": 1024}}}`, "host", []string{"api.example.com"}},
+	} {
+		options := makeDefaultOptions()
+		options.interpolate = true
+		schema, err := parseSchema(options, strings.NewReader(testPair.Schema))
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		options.schema = schema
+		setUpInterpolation(options, "config.example.com")
+
+		result, err := lookUpValues(options, records, testPair.Key, nil)
+		if err == nil && testPair.Result == nil {
+			t.Error("Expected error not caught for", testPair)
+		}
+		if err != nil && testPair.Result != nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestInterpolateBeforeTransforms(t *testing.T) {
+	options := makeDefaultOptions()
+	options.interpolate = true
+	options.valueType = "list"
+	if err := (&transformValue{&options.transforms, "--split", splitTransform, false}).Set(","); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	setUpInterpolation(options, "config.example.com")
+
+	values, err := lookUpValues(options, interpolateTestSources["config.example.com"], "allhosts", nil)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(values, []string{"api1.example.com", "api2.example.com"}) {
+		t.Error("Expected the referenced value to be split but got", values)
+	}
+}
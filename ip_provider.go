@@ -0,0 +1,85 @@
+package main
+
+// ip: sources (ip:192.0.2.10, ip:2001:db8::1), which are the TXT records at the address's reverse DNS name
+//
+// ?fallback=N uses the reverse name of the enclosing /N network instead (e.g., 2.0.192.in-addr.arpa for /24) when
+// the address's own name has no records.  CNAMEs are followed, even with --authoritative, for classless delegation
+// (https://tools.ietf.org/html/rfc2317).
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+type ipProvider struct {
+	host    *dnsProvider
+	network *dnsProvider
+}
+
+func makeIPProvider(options *options, nameserver string, address string, fallback int) (txtProvider, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, errors.Errorf("invalid IP address: %s", address)
+	}
+	hostName, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error making reverse name for %s", address)
+	}
+	host, err := makeDnsProvider(options, nameserver, hostName, dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	host.followCNAME = true
+	if fallback == 0 {
+		return host, nil
+	}
+
+	networkName, err := reverseNetworkName(ip, fallback)
+	if err != nil {
+		return nil, err
+	}
+	network := *host
+	network.domain = networkName
+	return &ipProvider{host, &network}, nil
+}
+
+// Reverse names only have labels for whole octets (IPv4) or nibbles (IPv6)
+func reverseNetworkName(ip net.IP, bits int) (string, error) {
+	labelBits, size := 8, 32
+	if ip.To4() == nil {
+		labelBits, size = 4, 128
+	}
+	if bits <= 0 || bits >= size || bits%labelBits != 0 {
+		return "", errors.Errorf("no reverse name for a /%d network of %s (the prefix length must be a multiple of %d, below %d)", bits, ip, labelBits, size)
+	}
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", err
+	}
+	labels := dns.SplitDomainName(name)
+	return dns.Fqdn(strings.Join(labels[(size-bits)/labelBits:], ".")), nil
+}
+
+func (p *ipProvider) getTxtRecords() ([]string, error) {
+	return p.withFallback(func(provider *dnsProvider) ([]string, error) {
+		return provider.getTxtRecords()
+	})
+}
+
+func (p *ipProvider) getKeyTxtRecords(key string) ([]string, error) {
+	return p.withFallback(func(provider *dnsProvider) ([]string, error) {
+		return provider.getKeyTxtRecords(key)
+	})
+}
+
+func (p *ipProvider) withFallback(fetch func(provider *dnsProvider) ([]string, error)) ([]string, error) {
+	records, err := fetch(p.host)
+	if _, missing := errors.Cause(err).(*noRecordsError); missing || err == nil && len(records) == 0 {
+		tracef(p.host.options, traceSummary, "no records at %s, falling back to %s", p.host.domain, p.network.domain)
+		return fetch(p.network)
+	}
+	return records, err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+type reverseNetworkNameTestPair struct {
+	Address string
+	Bits    int
+	Result  string
+	Err     error
+}
+
+func TestReverseNetworkName(t *testing.T) {
+	for _, testPair := range []reverseNetworkNameTestPair{
+		{"192.0.2.10", 24, "2.0.192.in-addr.arpa.", nil},
+		{"192.0.2.10", 8, "192.in-addr.arpa.", nil},
+		{"::ffff:192.0.2.10", 16, "0.192.in-addr.arpa.", nil},
+		{"2001:db8::1", 32, "8.b.d.0.1.0.0.2.ip6.arpa.", nil},
+		{"2001:db8::1", 36, "0.8.b.d.0.1.0.0.2.ip6.arpa.", nil},
+		{"192.0.2.10", 26, "", errors.New("Not whole octets")},
+		{"192.0.2.10", 32, "", errors.New("Not a network")},
+		{"192.0.2.10", 0, "", errors.New("Not a network")},
+		{"2001:db8::1", 62, "", errors.New("Not whole nibbles")},
+	} {
+		result, err := reverseNetworkName(net.ParseIP(testPair.Address), testPair.Bits)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+const ipTestZone = `
+2.0.192.in-addr.arpa. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 300
+2.0.192.in-addr.arpa. 300 IN TXT "site=ams"
+10.2.0.192.in-addr.arpa. 300 IN TXT "role=web"
+66.2.0.192.in-addr.arpa. 300 IN CNAME 66.64/26.2.0.192.in-addr.arpa.
+66.64/26.2.0.192.in-addr.arpa. 300 IN TXT "role=db"
+99.2.0.192.in-addr.arpa. 300 IN CNAME 98.2.0.192.in-addr.arpa.
+98.2.0.192.in-addr.arpa. 300 IN CNAME 99.2.0.192.in-addr.arpa.
+1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 300 IN TXT "role=dns"
+`
+
+type ipProviderTestPair struct {
+	Source string
+	Result []string
+	Err    error
+}
+
+func TestIPProvider(t *testing.T) {
+	var cnames []dns.RR
+	for token := range dns.ParseZone(strings.NewReader(ipTestZone), "", "") {
+		if token.Error != nil {
+			t.Fatal("Error", token.Error.Error(), "parsing test zone")
+		}
+		if _, ok := token.RR.(*dns.CNAME); ok {
+			cnames = append(cnames, token.RR)
+		}
+	}
+	// Like an authoritative server, answer with the CNAME but don't look up its target
+	address, shutdown := startTestServerWith(t, ipTestZone, func(response *dns.Msg) {
+		for _, cname := range cnames {
+			if strings.EqualFold(cname.Header().Name, response.Question[0].Name) {
+				response.Answer = append(response.Answer, cname)
+				response.Ns = nil
+			}
+		}
+	})
+	defer shutdown()
+
+	for _, testPair := range []ipProviderTestPair{
+		{"ip:192.0.2.10", []string{"role=web"}, nil},
+		{"ip:192.0.2.10?fallback=24", []string{"role=web"}, nil},
+		{"ip:2001:db8::1", []string{"role=dns"}, nil},
+		{"ip:192.0.2.66", []string{"role=db"}, nil},
+		{"ip:192.0.2.11?fallback=24", []string{"site=ams"}, nil},
+		{"ip:192.0.2.11", nil, errors.New("NXDOMAIN")},
+		{"ip:192.0.2.99", nil, errors.New("CNAME loop")},
+		{"ip:192.0.2.11?fallback=26", nil, errors.New("Bad prefix length")},
+		{"ip:192.0.2.300", nil, errors.New("Bad address")},
+		{"ip:192.0.2.10#x", nil, errors.New("Fragment")},
+	} {
+		options := makeDefaultOptions()
+		options.nameservers = []string{address}
+		var result []string
+		provider, err := getTxtProvider(options, testPair.Source)
+		if err == nil {
+			result, err = provider.getTxtRecords()
+		}
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
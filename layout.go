@@ -0,0 +1,242 @@
+package main
+
+// --layout per-name: each key gets its own DNS name, instead of the whole table being one TXT RRset
+//
+// Key "db.host" in example.com lives at db-host._cfg.example.com, and the TXT strings there are ordinary key=value
+// records (so keys that encode to the same name can share it).  The TXT strings at _index._cfg.example.com list every
+// key, one per string, so that the whole table can be fetched without zone transfers.
+//
+// --layout hashed is the same idea, but the name is a hash of the key (like OPENPGPKEY and SMIMEA records), so zone
+// walking doesn't reveal key names.  The TXT strings there are bare values, because a key=value record would give the
+// key name away to anyone who queried the name.  There's no index, so only single keys can be looked up.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	perNameLabel      = "_cfg"
+	perNameIndexLabel = "_index"
+	hashedLabel       = "_k"
+	// Same truncation as RFC 7929 (OPENPGPKEY) and RFC 8162 (SMIMEA)
+	hashedOctets = 28
+	// Maximum number of keys fetched at once when reading the whole table
+	perNameConcurrency = 8
+)
+
+// Sources that can fetch just the records for one key
+type keyTxtProvider interface {
+	getKeyTxtRecords(key string) ([]string, error)
+}
+
+// Whether each key has its own records, rather than the whole table being in one RRset
+func perKeyLayout(options *options) bool {
+	return options.layout == "per-name" || options.layout == "hashed"
+}
+
+// Fetches the records for one key after another.  With --layout rrset every key is in the same records, which only
+// need fetching once.
+type keyRecordsFetcher struct {
+	options  *options
+	provider txtProvider
+	records  []string
+	fetched  bool
+	total    int // Number of records fetched so far
+}
+
+// A missing name (NXDOMAIN) just means the key has no records
+func (f *keyRecordsFetcher) recordsFor(key string) ([]string, error) {
+	if f.fetched && !perKeyLayout(f.options) {
+		return f.records, nil
+	}
+	records, err := getKeyTxtRecords(f.provider, key)
+	if err != nil {
+		if _, missing := errors.Cause(err).(*noRecordsError); !missing {
+			return nil, err
+		}
+		records = nil
+	}
+	f.records, f.fetched = records, true
+	f.total += len(records)
+	return records, nil
+}
+
+// Encodes a key as one or more DNS labels:
+// * The key is first normalized according to --key-compare
+// * ASCII letters, digits and - are kept
+// * . becomes -
+// * Any other byte becomes _ followed by two lowercase hex digits (so a space is _20, and _ is _5f)
+// * The empty key is _
+// * The result is split into labels of at most 63 octets
+func keyLabels(options *options, key string) string {
+	key = normalizeKey(options, key)
+	if key == "" {
+		return "_"
+	}
+	var encoded strings.Builder
+	for _, b := range []byte(key) {
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9', b == '-':
+			encoded.WriteByte(b)
+		case b == '.':
+			encoded.WriteByte('-')
+		default:
+			fmt.Fprintf(&encoded, "_%02x", b)
+		}
+	}
+	var labels []string
+	for rest := encoded.String(); rest != ""; {
+		length := len(rest)
+		if length > 63 {
+			length = 63
+		}
+		labels = append(labels, rest[:length])
+		rest = rest[length:]
+	}
+	return strings.Join(labels, ".")
+}
+
+// Hex encoding of the first 28 octets of the SHA-256 of the normalized key followed by the --salt
+func keyHash(options *options, key string) string {
+	sum := sha256.Sum256([]byte(normalizeKey(options, key) + options.salt))
+	return hex.EncodeToString(sum[:hashedOctets])
+}
+
+// The owner name of a key's records, relative to domain, for the per-name and hashed layouts
+func keyOwner(options *options, key string) string {
+	if options.layout == "hashed" {
+		return keyHash(options, key) + "." + hashedLabel
+	}
+	return keyLabels(options, key) + "." + perNameLabel
+}
+
+func keyDomain(options *options, key string, domain string) string {
+	return keyOwner(options, key) + "." + domain
+}
+
+func (d *dnsProvider) getKeyTxtRecordsFrom(key string, nameservers []string) ([]string, error) {
+	if !perKeyLayout(d.options) {
+		return d.getTxtRecordsFrom(nameservers)
+	}
+	keyProvider := *d
+	keyProvider.domain = keyDomain(d.options, key, d.domain)
+	tracef(d.options, traceSummary, "key %q is at %s", key, keyProvider.domain)
+	records, err := keyProvider.getTxtRecordsFrom(nameservers)
+	if _, ok := errors.Cause(err).(*noRecordsError); ok {
+		// Just a missing key, so defaults can be used
+		return nil, nil
+	}
+	if err != nil || d.options.layout != "hashed" {
+		return records, err
+	}
+	return hashedKeyRecords(d.options, key, records), nil
+}
+
+// Turns the bare values at a hashed name into key=value records, so they can be read like any others
+// With --namespace, the values still have the namespace prefix (e.g., myapp:value), and it's kept in front.
+func hashedKeyRecords(options *options, key string, values []string) []string {
+	prefix := escapeKey(key) + "="
+	var records []string
+	for _, value := range values {
+		if options.namespace != "" {
+			stripped, ok := stripNamespace(options, value)
+			if !ok {
+				// Left for namespacedRecords() to skip
+				records = append(records, value)
+				continue
+			}
+			records = append(records, value[:len(value)-len(stripped)]+prefix+stripped)
+			continue
+		}
+		records = append(records, prefix+value)
+	}
+	return records
+}
+
+// A zone file line publishing value at owner, for sdget hash
+// The value is bare (plus any --namespace prefix), and split into strings of at most 255 octets.
+func hashedZoneRecord(options *options, owner string, value string) string {
+	if options.namespace != "" {
+		value = options.namespace + options.delimiter + value
+	}
+	var strs []string
+	for rest := value; ; {
+		length := len(rest)
+		if length > 255 {
+			length = 255
+		}
+		strs = append(strs, `"`+miekgQuoteTxt(rest[:length])+`"`)
+		rest = rest[length:]
+		if rest == "" {
+			break
+		}
+	}
+	return owner + "\tIN\tTXT\t" + strings.Join(strs, " ")
+}
+
+// Escapes a key with ` (RFC 1464) so that it parses back as the same key, without variants or qualifiers
+func escapeKey(key string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(key) {
+		switch b {
+		case '=', '`', '[', ']', '@', ' ', '\t':
+			escaped.WriteByte('`')
+		}
+		escaped.WriteByte(b)
+	}
+	return escaped.String()
+}
+
+// Reads the index, then fetches every key in it concurrently
+func (d *dnsProvider) getPerNameTxtRecordsFrom(nameservers []string) ([]string, error) {
+	if d.options.layout == "hashed" {
+		return nil, errors.New("keys in the hashed layout can't be listed, so only single keys can be looked up")
+	}
+	indexProvider := *d
+	indexProvider.domain = perNameIndexLabel + "." + perNameLabel + "." + d.domain
+	keys, err := indexProvider.getTxtRecordsFrom(nameservers)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading per-name layout index")
+	}
+
+	domains := make(map[string]bool)
+	var uniqueKeys []string
+	for _, key := range keys {
+		domain := keyDomain(d.options, key, d.domain)
+		if !domains[domain] {
+			domains[domain] = true
+			uniqueKeys = append(uniqueKeys, key)
+		}
+	}
+	tracef(d.options, traceSummary, "index lists %d key(s)", len(uniqueKeys))
+
+	results := make([][]string, len(uniqueKeys))
+	errs := make([]error, len(uniqueKeys))
+	limit := make(chan struct{}, perNameConcurrency)
+	var wg sync.WaitGroup
+	for i, key := range uniqueKeys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			results[i], errs[i] = d.getKeyTxtRecordsFrom(key, nameservers)
+		}(i, key)
+	}
+	wg.Wait()
+
+	var records []string
+	for i, key := range uniqueKeys {
+		if errs[i] != nil {
+			return nil, errors.Wrapf(errs[i], "error fetching key %q", key)
+		}
+		records = append(records, results[i]...)
+	}
+	return records, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+type keyLabelsTestPair struct {
+	Key    string
+	Labels string
+}
+
+func TestKeyLabels(t *testing.T) {
+	options := makeDefaultOptions()
+	for _, testPair := range []keyLabelsTestPair{
+		{"db.host", "db-host"},
+		{"DB.Host", "db-host"},
+		{"list-key", "list-key"},
+		{"Some Key", "some_20key"},
+		{"snake_case", "snake_5fcase"},
+		{"key=with=equals", "key_3dwith_3dequals"},
+		{"Straße", "strasse"},
+		{"Café", "caf_c3_a9"},
+		{"STRASSE", "strasse"},
+		{"", "_"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63) + ".aaaaaaa"},
+	} {
+		labels := keyLabels(options, testPair.Key)
+		if labels != testPair.Labels {
+			t.Error("Expected", testPair.Labels, "but got", labels, "for", testPair)
+		}
+	}
+}
+
+const perNameTestZone = `
+_index._cfg.example.com. 300 IN TXT "db.host"
+_index._cfg.example.com. 300 IN TXT "db.port"
+_index._cfg.example.com. 300 IN TXT "db-host"
+_index._cfg.example.com. 300 IN TXT "servers"
+_index._cfg.example.com. 300 IN TXT "unset"
+db-host._cfg.example.com. 300 IN TXT "db.host=db1.example.com"
+db-host._cfg.example.com. 300 IN TXT "db-host=shared name"
+db-port._cfg.example.com. 300 IN TXT "db.port=5432"
+servers._cfg.example.com. 300 IN TXT "servers=a"
+servers._cfg.example.com. 300 IN TXT "servers=b"
+`
+
+type perNameTestPair struct {
+	Key     string
+	Records []string
+}
+
+func TestPerNameLayout(t *testing.T) {
+	address, shutdown := startTestServer(t, perNameTestZone)
+	defer shutdown()
+	options := makeDefaultOptions()
+	options.layout = "per-name"
+
+	provider, err := makeDnsProvider(options, address, "example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	for _, testPair := range []perNameTestPair{
+		{"db.host", []string{"db.host=db1.example.com", "db-host=shared name"}},
+		{"DB.PORT", []string{"db.port=5432"}},
+		{"servers", []string{"servers=a", "servers=b"}},
+		{"unset", nil},
+	} {
+		records, err := provider.getKeyTxtRecords(testPair.Key)
+		if err != nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+			continue
+		}
+		if !reflect.DeepEqual(records, testPair.Records) {
+			t.Error("Expected", testPair.Records, "but got", records, "for", testPair)
+		}
+	}
+
+	records, err := provider.getTxtRecords()
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	expected := []string{"db.host=db1.example.com", "db-host=shared name", "db.port=5432", "servers=a", "servers=b"}
+	if !reflect.DeepEqual(records, expected) {
+		t.Error("Expected", expected, "but got", records)
+	}
+	values, err := lookUpValues(options, records, "db-host", nil)
+	if err != nil || !reflect.DeepEqual(values, []string{"shared name"}) {
+		t.Error("Expected [shared name] but got", values, err)
+	}
+}
+
+func TestPerNameLayoutMissingIndex(t *testing.T) {
+	address, shutdown := startTestServer(t, `db-host._cfg.example.com. 300 IN TXT "db.host=db1"`)
+	defer shutdown()
+	options := makeDefaultOptions()
+	options.layout = "per-name"
+
+	provider, err := makeDnsProvider(options, address, "example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if _, err := provider.getTxtRecords(); err == nil {
+		t.Error("Expected error not caught for missing index")
+	}
+}
+
+func TestRRsetLayoutKeyLookup(t *testing.T) {
+	address, shutdown := startTestServer(t, `example.com. 300 IN TXT "db.host=db1"`)
+	defer shutdown()
+	options := makeDefaultOptions()
+
+	provider, err := makeDnsProvider(options, address, "example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	records, err := provider.getKeyTxtRecords("db.host")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(records, []string{"db.host=db1"}) {
+		t.Error("Expected [db.host=db1] but got", records)
+	}
+}
+
+type keyHashTestPair struct {
+	Key  string
+	Salt string
+	Hash string
+}
+
+func TestKeyHash(t *testing.T) {
+	for _, testPair := range []keyHashTestPair{
+		{"db.host", "", "f8ab13741919d70c2300665f7f9136e780e62528fa1fdbd48fb5e729"},
+		{"DB.Host", "", "f8ab13741919d70c2300665f7f9136e780e62528fa1fdbd48fb5e729"},
+		{"db.host", "pepper", "c57e7b3f61eb001b256c0a6fc5debbf7f0810346c2e48f751b54477f"},
+		{"", "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b"},
+	} {
+		options := makeDefaultOptions()
+		options.salt = testPair.Salt
+		hash := keyHash(options, testPair.Key)
+		if hash != testPair.Hash {
+			t.Error("Expected", testPair.Hash, "but got", hash, "for", testPair)
+		}
+	}
+}
+
+func TestHashedLayout(t *testing.T) {
+	options := makeDefaultOptions()
+	options.layout = "hashed"
+	options.salt = "pepper"
+	zone := keyDomain(options, "db.host", "example.com.") + ` 300 IN TXT "db1.example.com"
+` + keyDomain(options, " odd=key ", "example.com.") + ` 300 IN TXT "a=b"`
+	address, shutdown := startTestServer(t, zone)
+	defer shutdown()
+
+	provider, err := makeDnsProvider(options, address, "example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	for _, testPair := range []perNameTestPair{
+		{"db.host", []string{"db.host=db1.example.com"}},
+		{"DB.HOST", []string{"DB.HOST=db1.example.com"}},
+		{" odd=key ", []string{"` odd`=key` =a=b"}},
+		{"db-host", nil},
+	} {
+		records, err := provider.getKeyTxtRecords(testPair.Key)
+		if err != nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+			continue
+		}
+		if !reflect.DeepEqual(records, testPair.Records) {
+			t.Error("Expected", testPair.Records, "but got", records, "for", testPair)
+		}
+	}
+
+	records, err := provider.getKeyTxtRecords(" odd=key ")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	values, err := lookUpValues(options, records, " odd=key ", nil)
+	if err != nil || !reflect.DeepEqual(values, []string{"a=b"}) {
+		t.Error("Expected [a=b] but got", values, err)
+	}
+
+	if _, err := provider.getTxtRecords(); err == nil {
+		t.Error("Expected error not caught for listing hashed keys")
+	}
+
+	options.salt = ""
+	if records, _ := provider.getKeyTxtRecords("db.host"); records != nil {
+		t.Error("Expected no records with the wrong salt but got", records)
+	}
+}
+
+func TestHashedKeyRecords(t *testing.T) {
+	options := makeDefaultOptions()
+	options.namespace = "myapp"
+	records := hashedKeyRecords(options, "db.host", []string{"myapp:db1", "other:db2", "db3"})
+	expected := []string{"myapp:db.host=db1", "other:db2", "db3"}
+	if !reflect.DeepEqual(records, expected) {
+		t.Error("Expected", expected, "but got", records)
+	}
+	values, err := lookUpValues(options, records, "db.host", nil)
+	if err != nil || !reflect.DeepEqual(values, []string{"db1"}) {
+		t.Error("Expected [db1] but got", values, err)
+	}
+}
+
+func TestHashedZoneRecord(t *testing.T) {
+	options := makeDefaultOptions()
+	for _, testPair := range []struct {
+		Namespace string
+		Value     string
+		Record    string
+	}{
+		{"", "db1.example.com", "h._k.example.com.\tIN\tTXT\t\"db1.example.com\""},
+		{"myapp", `say "hi"`, "h._k.example.com.\tIN\tTXT\t\"myapp:say \\\"hi\\\"\""},
+		{"", "", "h._k.example.com.\tIN\tTXT\t\"\""},
+		{"", strings.Repeat("a", 300), "h._k.example.com.\tIN\tTXT\t\"" + strings.Repeat("a", 255) + "\" \"" + strings.Repeat("a", 45) + "\""},
+	} {
+		options.namespace = testPair.Namespace
+		record := hashedZoneRecord(options, "h._k.example.com.", testPair.Value)
+		if record != testPair.Record {
+			t.Error("Expected", testPair.Record, "but got", record, "for", testPair)
+		}
+		if _, err := dns.NewRR(record); err != nil {
+			t.Error("Error parsing", record, err.Error())
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -16,6 +22,10 @@ type txtProvider interface {
 }
 
 func getTxtProvider(options *options, source string) (txtProvider, error) {
+	source, err := resolveAlias(options, source)
+	if err != nil {
+		return nil, err
+	}
 	if strings.ContainsRune(source, ':') {
 		uri, err := parseURI(source)
 		if err != nil {
@@ -24,8 +34,9 @@ func getTxtProvider(options *options, source string) (txtProvider, error) {
 		switch uri.scheme {
 		case "dns":
 			domain := uri.path
-			if uri.query != "" {
-				return nil, fmt.Errorf("unexpected \"%s\": queries in DNS URIs not supported", uri.query)
+			qclass, qtype, err := parseDnsQuery(uri.query)
+			if err != nil {
+				return nil, err
 			}
 			if uri.fragment != "" {
 				return nil, fmt.Errorf("unexpected \"%s\": fragments in DNS URIs not supported", uri.fragment)
@@ -33,7 +44,17 @@ func getTxtProvider(options *options, source string) (txtProvider, error) {
 			if strings.HasPrefix(domain, "/") {
 				domain = domain[1:len(domain)]
 			}
-			return makeDnsProvider(options, uri.authority, domain)
+			return makeDnsProvider(options, uri.authority, domain, qclass, qtype)
+
+		case "ip":
+			fallback, err := parseIPQuery(uri.query)
+			if err != nil {
+				return nil, err
+			}
+			if uri.fragment != "" {
+				return nil, fmt.Errorf("unexpected \"%s\": fragments in IP URIs not supported", uri.fragment)
+			}
+			return makeIPProvider(options, uri.authority, strings.TrimPrefix(uri.path, "/"), fallback)
 
 		case "file":
 			if uri.query != "" {
@@ -48,19 +69,64 @@ func getTxtProvider(options *options, source string) (txtProvider, error) {
 			return nil, fmt.Errorf("Unsupported URI scheme: %s", uri.scheme)
 		}
 	}
-	return makeDnsProvider(options, "", source)
+	return makeDnsProvider(options, "", source, dns.ClassINET, dns.TypeTXT)
+}
+
+// Whether source (a URI, domain name or alias) is a local file.  Data from DNS mustn't be able to make sdget read local
+// files, so only other files can refer to them.
+func isFileSource(options *options, source string) bool {
+	source, err := resolveAlias(options, strings.TrimSpace(source))
+	if err != nil || !strings.ContainsRune(source, ':') {
+		return false
+	}
+	uri, err := parseURI(source)
+	return err == nil && strings.EqualFold(uri.scheme, "file")
 }
 
 type options struct {
-	outputFormat string
-	valueType    string
-	nameserver   string
+	outputFormat  string
+	encoding      string
+	valueType     string
+	nameservers   []string
+	race          int
+	keyCompare    string
+	layout        string
+	resolver      string
+	namespace     string
+	delimiter     string
+	salt          string
+	pick          string
+	seed          *int64
+	at            time.Time
+	selectors     variant
+	interpolate   bool
+	transforms    []transform
+	authoritative bool
+	aliases       map[string]string
+	tsigKeyFile   string
+	tsig          *tsigKey
+	session       *session
+	schemaFile    string
+	schema        *schema
+	fileSource    bool           // The table being looked up is a local file, so its _include records can name files
+	dnssecOK      bool           // Ask for signatures (the DO bit), for sdget check
+	onResponse    func(*dns.Msg) // Called with each usable answer, for sdget check
+	queryTimeout  time.Duration  // Instead of exchangeTimeout, for shell completion
+	expandValues  valueExpander  // Expands references in stored values, for --interpolate
+	verbosity     int
+	secretKeys    []string
+	traceSink     io.Writer
 }
 
 func makeDefaultOptions() *options {
 	return &options{
 		outputFormat: "plain",
+		encoding:     "raw",
 		valueType:    "single",
+		keyCompare:   "unicode",
+		layout:       "rrset",
+		resolver:     "builtin",
+		delimiter:    ":",
 	}
 }
 
@@ -75,22 +141,28 @@ func output(options *options, sink io.Writer, values []string) error {
 		encoder.SetEscapeHTML(false)
 		switch options.valueType {
 		case "single":
-			err = encoder.Encode(values[0])
+			err = encoder.Encode(jsonValues(options, values)[0])
 		case "list":
-			err = encoder.Encode(values)
+			err = encoder.Encode(jsonValues(options, values))
 		}
 		if err != nil {
 			return errors.Wrap(err, "error writing JSON")
 		}
 	case "plain":
+		if err := checkSeparator(options, values, "\n", "newline"); err != nil {
+			return err
+		}
 		for _, record := range values {
-			if _, err := fmt.Fprintln(sink, record); err != nil {
+			if _, err := fmt.Fprintln(sink, encodeValue(options, record)); err != nil {
 				return err
 			}
 		}
 	case "zero":
+		if err := checkSeparator(options, values, "\000", "NUL"); err != nil {
+			return err
+		}
 		for _, record := range values {
-			if _, err := fmt.Fprintf(sink, "%s\000", record); err != nil {
+			if _, err := fmt.Fprintf(sink, "%s\000", encodeValue(options, record)); err != nil {
 				return err
 			}
 		}
@@ -99,14 +171,48 @@ func output(options *options, sink io.Writer, values []string) error {
 }
 
 func splitRecord(record string) (isRecord bool, key string, value string) {
+	isRecord, parsed, err := parseRecord(record)
+	if err != nil {
+		return false, "", ""
+	}
+	return isRecord, parsed.key, parsed.value
+}
+
+// A key/value pair, plus any variant (see variant.go) and validity window (see validity.go) from the key
+type parsedRecord struct {
+	key     string
+	value   string
+	variant variant
+	window  validity
+}
+
+// The error is for records that were meant to have a variant or qualifiers, but they can't be parsed
+func parseRecord(record string) (bool, parsedRecord, error) {
+	isRecord, parsed, err := scanRecord(record, true)
+	if !isRecord && strings.ContainsRune(record, '[') {
+		// Unbalanced brackets in keys meant something literal before variants existed
+		return scanRecord(record, false)
+	}
+	return isRecord, parsed, err
+}
+
+func scanRecord(record string, brackets bool) (bool, parsedRecord, error) {
 	// Implements the rules in https://tools.ietf.org/html/rfc1464#page-2
 	// * Escaping done with `
 	// * Unescaped trailing and leading tabs and spaces removed
 	// * Key ends with unescaped =
+	// Keys are returned as-is; use normalizeKey() before comparing them.
+	// Extensions:
+	// * An unescaped [...] containing = at the end of the key is a variant
+	// * An unescaped @from= or @until= after that starts validity qualifiers
 	record = strings.TrimLeft(record, " \t")
 	var bkey []byte
 	escapeNext := false
 	numTrailingWhitespace := 0
+	inBrackets := false
+	keyEnd := 0        // End of the key without the variant
+	variantStart := -1 // Start of the variant in bkey
+	variantEnd := -1   // End of the variant in bkey (just after the ])
 	for i, c := range []byte(record) {
 		if escapeNext {
 			numTrailingWhitespace = 0
@@ -120,10 +226,46 @@ func splitRecord(record string) (isRecord bool, key string, value string) {
 			continue
 		}
 
-		if c == '=' {
+		if inBrackets {
+			bkey = append(bkey, c)
+			if c == ']' {
+				inBrackets = false
+				variantEnd = len(bkey)
+				numTrailingWhitespace = 0
+			}
+			continue
+		}
+
+		if brackets && c == '[' {
+			inBrackets = true
+			keyEnd = len(bkey) - numTrailingWhitespace
+			variantStart = len(bkey)
+			bkey = append(bkey, c)
+			continue
+		}
+
+		if c == '=' || c == '@' && isQualifier(record[i:]) {
 			bkey = bkey[0 : len(bkey)-numTrailingWhitespace]
-			key = strings.ToLower(string(bkey))
-			return true, key, record[i+1 : len(record)]
+			parsed := parsedRecord{key: string(bkey)}
+			var err error
+			// Brackets without conditions (e.g., list[0]) are just part of the key
+			if variantStart >= 0 && variantEnd == len(bkey) && bytes.IndexByte(bkey[variantStart:], '=') >= 0 {
+				parsed.key = string(bkey[:keyEnd])
+				parsed.variant, err = parseVariant(string(bkey[variantStart+1 : variantEnd-1]))
+			}
+			if c == '=' {
+				parsed.value = record[i+1 : len(record)]
+				return true, parsed, err
+			}
+			window, rest, ok, qualifierErr := parseQualifiers(record[i:])
+			if !ok {
+				return false, parsedRecord{}, nil
+			}
+			parsed.value, parsed.window = rest, window
+			if err == nil {
+				err = qualifierErr
+			}
+			return true, parsed, err
 		}
 
 		if c == ' ' || c == '\t' {
@@ -133,22 +275,85 @@ func splitRecord(record string) (isRecord bool, key string, value string) {
 		}
 		bkey = append(bkey, c)
 	}
-	return false, "", ""
+	return false, parsedRecord{}, nil
 }
 
-func lookUpValues(options *options, txtRecords []string, key string, defaultValues []string) ([]string, error) {
-	key = strings.ToLower(key)
-	var values []string
+// Keys are case-insensitive.  RFC1464 only talks about ASCII, so by default we use full Unicode case folding, plus NFC
+// normalization so that precomposed and decomposed characters match.  The "ascii" mode is the plain lowercasing used
+// by older versions.
+func normalizeKey(options *options, key string) string {
+	if options.keyCompare == "ascii" {
+		return strings.ToLower(key)
+	}
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(key)))
+}
+
+// With --namespace, only strings starting with the namespace and delimiter (e.g., "myapp:") are used, minus the prefix.
+// The namespace is compared like a key.
+func namespacedRecords(options *options, txtRecords []string) []string {
+	if options.namespace == "" {
+		return txtRecords
+	}
+	var results []string
 	for _, record := range txtRecords {
-		isRecord, recordKey, recordValue := splitRecord(record)
-		if isRecord && recordKey == key {
-			values = append(values, recordValue)
+		stripped, ok := stripNamespace(options, record)
+		if !ok {
+			tracef(options, traceDetail, "ignoring %q: not in namespace %q", redactRecord(options, record), options.namespace)
+			continue
+		}
+		results = append(results, stripped)
+	}
+	return results
+}
+
+func stripNamespace(options *options, record string) (string, bool) {
+	i := strings.Index(record, options.delimiter)
+	if i < 0 || normalizeKey(options, record[:i]) != normalizeKey(options, options.namespace) {
+		return "", false
+	}
+	return record[i+len(options.delimiter):], true
+}
+
+// Fetches just the records for key if the source supports that (e.g., with --layout per-name)
+func getKeyTxtRecords(provider txtProvider, key string) ([]string, error) {
+	if keyProvider, ok := provider.(keyTxtProvider); ok {
+		return keyProvider.getKeyTxtRecords(key)
+	}
+	return provider.getTxtRecords()
+}
+
+func lookUpValues(options *options, txtRecords []string, key string, defaultValues []string) ([]string, error) {
+	key = normalizeKey(options, key)
+	values, err := storedValues(options, txtRecords, key)
+	if err != nil {
+		return nil, err
+	}
+	if options.expandValues != nil {
+		if values, err = options.expandValues(key, txtRecords, values); err != nil {
+			return nil, err
+		}
+	}
+	if options.schema != nil {
+		if violations := options.schema.violations(options, key, values); len(violations) > 0 {
+			return nil, errors.Errorf("values of key %s don't match the schema: %s", key, strings.Join(violations, "; "))
 		}
 	}
 	if len(values) == 0 {
+		tracef(options, traceSummary, "no values matched key %q, using %d default value(s)", key, len(defaultValues))
 		values = defaultValues
 	}
 
+	values = applyTransforms(options, values)
+
+	if options.pick != "" && len(values) > 0 {
+		picked, err := pickValue(options, values)
+		if err != nil {
+			return nil, err
+		}
+		tracef(options, traceSummary, "picked %q from %d value(s) using %s", redactValue(options, key, picked), len(values), options.pick)
+		values = []string{picked}
+	}
+
 	if options.valueType == "single" {
 		if len(values) == 0 {
 			return nil, errors.Errorf("no values found for key %s, and no default provided", key)
@@ -161,43 +366,269 @@ func lookUpValues(options *options, txtRecords []string, key string, defaultValu
 	return values, nil
 }
 
+// The values of the (normalized) key as they're stored, in these records or any _include records' sources
+func storedValues(options *options, txtRecords []string, key string) ([]string, error) {
+	values, err := findValues(options, txtRecords, key)
+	if err != nil || len(values) > 0 {
+		return values, err
+	}
+	return makeIncludeState().findIncludedValues(options, txtRecords, key)
+}
+
+// The values of the (normalized) key in just these records, after applying --namespace, --select and validity windows
+func findValues(options *options, txtRecords []string, key string) ([]string, error) {
+	txtRecords = namespacedRecords(options, txtRecords)
+	var matches []qualifiedValue
+	traceRecords(options, txtRecords)
+	for _, record := range txtRecords {
+		isRecord, parsed, err := parseRecord(record)
+		if !isRecord || normalizeKey(options, parsed.key) != key {
+			continue
+		}
+		if err != nil {
+			warnf(options, "ignoring %q: %s", redactRecord(options, record), err.Error())
+			continue
+		}
+		tracef(options, traceSummary, "key %q matched value %q (variant %s, valid %s)", parsed.key, redactValue(options, parsed.key, parsed.value), parsed.variant, parsed.window)
+		matches = append(matches, qualifiedValue{parsed.value, parsed.variant, parsed.window})
+	}
+	return selectVariant(options, key, matches, lookupTime(options))
+}
+
 func main() {
 	options := makeDefaultOptions()
+	args := os.Args[1:]
 	kingpin.Version("0.4.0")
 	kingpin.CommandLine.HelpFlag.Short('h')
+	setUpCompletion(kingpin.CommandLine)
+	kingpin.Flag("at", "Time to evaluate time-bounded values and flag rules at, instead of now (e.g., 2026-11-01T09:30Z)").PlaceHolder("TIME").Envar("SDGET_AT").SetValue(&timeValue{&options.at})
+	kingpin.Flag("authoritative", "Query the zone's authoritative nameservers directly, bypassing caches").Envar("SDGET_AUTHORITATIVE").BoolVar(&options.authoritative)
+	kingpin.Flag("config", "Config file with profiles and source aliases (default: $XDG_CONFIG_HOME/sdget/config.toml)").PlaceHolder("FILE").Envar("SDGET_CONFIG").String()
+	kingpin.Flag("encoding", "Encoding of output values (raw, base64, hex, c-escape)").Default("raw").Envar("SDGET_ENCODING").EnumVar(&options.encoding, "raw", "base64", "hex", "c-escape")
+	kingpin.Flag("extract", "Replace each value with the match of REGEX's one group, dropping values that don't match").PlaceHolder("REGEX").SetValue(&transformValue{&options.transforms, "--extract", extractTransform, false})
 	kingpin.Flag("format", "Output format (json, plain, zero)").Short('f').Default("plain").Envar("SDGET_FORMAT").EnumVar(&options.outputFormat, "json", "plain", "zero")
-	kingpin.Flag("nameserver", "Default nameserver address (ns.example.com:53, 127.0.0.1)").Short('@').Envar("SDGET_NAMESERVER").StringVar(&options.nameserver)
+	kingpin.Flag("interpolate", "Expand ${key} and ${source#key} references in values").Envar("SDGET_INTERPOLATE").BoolVar(&options.interpolate)
+	kingpin.Flag("key-compare", "Key comparison (unicode, ascii)").Default("unicode").Envar("SDGET_KEY_COMPARE").EnumVar(&options.keyCompare, "unicode", "ascii")
+	kingpin.Flag("layout", "How keys are stored in DNS (rrset, per-name, hashed)").Default("rrset").Envar("SDGET_LAYOUT").EnumVar(&options.layout, "rrset", "per-name", "hashed")
+	kingpin.Flag("limit", "Keep only the first N values").PlaceHolder("N").SetValue(&transformValue{&options.transforms, "--limit", limitTransform, false})
+	kingpin.Flag("nameserver", "Default nameserver address (ns.example.com:53, 127.0.0.1), repeatable for failover").Short('@').Envar("SDGET_NAMESERVER").StringsVar(&options.nameservers)
+	kingpin.Flag("namespace", "Only use TXT strings prefixed with NAME and the delimiter (e.g., myapp:key=value)").PlaceHolder("NAME").Envar("SDGET_NAMESPACE").StringVar(&options.namespace)
+	kingpin.Flag("namespace-delimiter", "Separator between the namespace and the key").Default(":").Envar("SDGET_NAMESPACE_DELIMITER").StringVar(&options.delimiter)
+	kingpin.Flag("pick", "Pick one of several values (first, random, weighted, hash:SUBJECT)").PlaceHolder("STRATEGY").Envar("SDGET_PICK").StringVar(&options.pick)
+	kingpin.Flag("profile", "Profile of the config file to use (default: \"default\", if there is one)").PlaceHolder("NAME").Envar("SDGET_PROFILE").String()
+	kingpin.Flag("race", "Query up to N nameservers at once and use the first good answer").PlaceHolder("N").Default("1").Envar("SDGET_RACE").IntVar(&options.race)
+	recordSession := kingpin.Flag("record-session", "Save every DNS query and response to FILE, for --replay-session").PlaceHolder("FILE").Envar("SDGET_RECORD_SESSION").String()
+	replaySession := kingpin.Flag("replay-session", "Answer DNS queries from a session saved with --record-session, instead of the network").PlaceHolder("FILE").Envar("SDGET_REPLAY_SESSION").String()
+	kingpin.Flag("resolver", "DNS client to use: sdget's own (builtin), or the host's resolver (system), with fewer features").Default("builtin").Envar("SDGET_RESOLVER").EnumVar(&options.resolver, "builtin", "system")
+	kingpin.Flag("salt", "Salt for key name hashes with --layout hashed").Envar("SDGET_SALT").StringVar(&options.salt)
+	kingpin.Flag("select", "Use variants of keys for these conditions, e.g., env=prod,region=eu (repeatable)").PlaceHolder("NAME=VALUE,...").Envar("SDGET_SELECT").SetValue(&selectorsValue{&options.selectors})
+	kingpin.Flag("seed", "Random seed for --pick random and weighted, for reproducible picks").Envar("SDGET_SEED").SetValue(&seedValue{&options.seed})
+	kingpin.Flag("schema", "Refuse values that don't match the JSON schema in FILE").PlaceHolder("FILE").Envar("SDGET_SCHEMA").StringVar(&options.schemaFile)
+	kingpin.Flag("secret", "Key whose values are redacted from verbose output (repeatable)").PlaceHolder("KEY").Envar("SDGET_SECRET").StringsVar(&options.secretKeys)
+	kingpin.Flag("sort", "Sort values").SetValue(&transformValue{&options.transforms, "--sort", sortTransform, true})
+	kingpin.Flag("split", "Split each value into several at SEPARATOR").PlaceHolder("SEPARATOR").SetValue(&transformValue{&options.transforms, "--split", splitTransform, false})
+	kingpin.Flag("trim", "Trim whitespace from the ends of values").SetValue(&transformValue{&options.transforms, "--trim", trimTransform, true})
+	kingpin.Flag("tsig-key", "Sign queries with the TSIG key in FILE (BIND format or ALGORITHM:NAME:SECRET)").PlaceHolder("FILE").Envar("SDGET_TSIG_KEY").StringVar(&options.tsigKeyFile)
 	kingpin.Flag("type", "Data value type (single, list)").Short('t').Default("single").Envar("SDGET_TYPE").EnumVar(&options.valueType, "single", "list")
-	source := kingpin.Arg("source", "URI or domain name to query for TXT records").Required().String()
-	key := kingpin.Arg("key", "Key name to look up in source").Required().String()
-	defaultValues := kingpin.Arg("default", "Default value(s) to use if key is not found").Strings()
-	kingpin.Parse()
+	kingpin.Flag("unique", "Drop repeated values").SetValue(&transformValue{&options.transforms, "--unique", uniqueTransform, true})
+	kingpin.Flag("verbose", "Trace the lookup on stderr (repeat for full DNS messages and record parsing)").Short('v').CounterVar(&options.verbosity)
+
+	kingpin.CommandLine.Validate(func(*kingpin.Application) error {
+		// Every string would start with an empty delimiter, so nothing would ever be in the namespace
+		if options.namespace != "" && options.delimiter == "" {
+			return errors.New("--namespace-delimiter can't be empty when --namespace is set")
+		}
+		if options.pick != "" {
+			if _, _, err := parsePickStrategy(options.pick); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	getCommand := kingpin.Command("get", "Look up a key (default command)").Default()
+	source := getCommand.Arg("source", "URI or domain name to query for TXT records").Required().HintAction(func() []string { return completeFirstArgument(options, kingpin.CommandLine, args) }).String()
+	key := getCommand.Arg("key", "Key name to look up in source").Required().HintAction(func() []string { return completeKeys(options, *source) }).String()
+	defaultValues := getCommand.Arg("default", "Default value(s) to use if key is not found").Strings()
+
+	doctorCommand := kingpin.Command("doctor", "Check the resolver configuration for common problems")
+	doctorDomain := doctorCommand.Arg("domain", "Domain name with TXT records to test response sizes and consistency with").String()
+
+	waitCommand := kingpin.Command("wait", "Wait until a key has the expected value(s) on every authoritative server (exit code 8 if --timeout passes first)")
+	waitOptions := makeDefaultWaitOptions()
+	waitCommand.Flag("server", "Nameserver to poll instead of the authoritative servers (repeatable)").PlaceHolder("NAMESERVER").StringsVar(&waitOptions.servers)
+	waitSerial := waitCommand.Flag("serial", "Wait for the zone's SOA serial to be at least N, instead of checking a key").PlaceHolder("N").String()
+	waitCommand.Flag("timeout", "How long to wait before giving up (0 checks once)").Default("5m").DurationVar(&waitOptions.timeout)
+	waitSource := waitCommand.Arg("source", "URI or domain name to query for TXT records").Required().HintAction(func() []string { return completeSources(options) }).String()
+	waitKey := waitCommand.Arg("key", "Key name to look up in source").HintAction(func() []string { return completeKeys(options, *waitSource) }).String()
+	waitExpected := waitCommand.Arg("expected", "Expected value(s) of key").Strings()
+
+	hashCommand := kingpin.Command("hash", "Print the owner name of a key for --layout hashed")
+	hashKey := hashCommand.Arg("key", "Key name to hash").Required().String()
+	hashDomain := hashCommand.Arg("domain", "Domain name to append").String()
+	hashValues := hashCommand.Arg("value", "Value(s) to print TXT records to publish for").Strings()
+
+	flagCommand := kingpin.Command("flag", "Evaluate a feature flag rule (exit code 0 for on, 1 for off)")
+	flagSubject := flagCommand.Flag("subject", "Host or other ID to evaluate the flag for (default: hostname)").String()
+	flagSource := flagCommand.Arg("source", "URI or domain name to query for TXT records").Required().HintAction(func() []string { return completeSources(options) }).String()
+	flagName := flagCommand.Arg("name", "Key name of the flag").Required().HintAction(func() []string { return completeKeys(options, *flagSource) }).String()
+	flagDefault := flagCommand.Arg("default", "Rule to use if the flag isn't found").String()
+
+	checkCommand := kingpin.Command("check", "Check that a source has the expected records, as a monitoring plugin (exit code 0 OK, 1 WARNING, 2 CRITICAL, 3 UNKNOWN)")
+	checkOptions := &checkOptions{}
+	checkCommand.Flag("signature-critical", "CRITICAL if a signature expires within DURATION (e.g., 72h)").PlaceHolder("DURATION").StringVar(&checkOptions.signatureCritical)
+	checkCommand.Flag("signature-warning", "WARNING if a signature expires within DURATION (e.g., 168h)").PlaceHolder("DURATION").StringVar(&checkOptions.signatureWarning)
+	checkCommand.Flag("ttl-critical", "CRITICAL if the TTL in seconds is outside RANGE (e.g., 60:3600)").PlaceHolder("RANGE").StringVar(&checkOptions.ttlCritical)
+	checkCommand.Flag("ttl-warning", "WARNING if the TTL in seconds is outside RANGE (e.g., 300:)").PlaceHolder("RANGE").StringVar(&checkOptions.ttlWarning)
+	checkSource := checkCommand.Arg("source", "URI or domain name to query for TXT records").Required().HintAction(func() []string { return completeSources(options) }).String()
+	checkExpectations := checkCommand.Arg("expectation", "Expected record: key=value, key~=regex or key? (present)").Strings()
+
+	validateCommand := kingpin.Command("validate", "Check a source against the schema given with --schema, reporting every violation")
+	// --schema is a global flag, so it can't be Required() just for this command, but kingpin can still report it as
+	// a usage error
+	validateCommand.Action(func(*kingpin.ParseContext) error {
+		if options.schemaFile == "" {
+			return errors.New("required flag --schema not provided")
+		}
+		return nil
+	})
+	validateSource := validateCommand.Arg("source", "URI or domain name to query for TXT records").Required().HintAction(func() []string { return completeSources(options) }).String()
+
+	configCommand := kingpin.Command("config", "Inspect the config file")
+	configShowCommand := configCommand.Command("show", "Print the effective settings, and where each came from")
+
+	// As a monitoring plugin, sdget check has to exit with UNKNOWN if it can't even start, because 1 and 2 mean
+	// WARNING and CRITICAL.  sdget flag exits 1 for off, so its usage errors are reported like setup errors.
+	usageExit, setupExit := 1, 2
+	switch selectedCommand(kingpin.CommandLine, args) {
+	case checkCommand.FullCommand():
+		usageExit, setupExit = int(checkUnknown), int(checkUnknown)
+	case flagCommand.FullCommand():
+		usageExit = setupExit
+	}
+
+	config, err := findConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err.Error())
+		os.Exit(setupExit)
+	}
+	profile, err := applyProfile(kingpin.CommandLine, config, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err.Error())
+		os.Exit(setupExit)
+	}
+	options.aliases = config.aliases
+	args = expandAliasArgs(config, args)
+
+	command, err := kingpin.CommandLine.Parse(args)
+	if err != nil {
+		kingpin.CommandLine.Errorf("%s, try --help", err)
+		os.Exit(usageExit)
+	}
+	options.traceSink = os.Stderr
+
+	if options.tsigKeyFile != "" && command != configShowCommand.FullCommand() {
+		if options.tsig, err = loadTsigKey(options.tsigKeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+			os.Exit(setupExit)
+		}
+	}
+
+	if command != configShowCommand.FullCommand() {
+		if options.session, err = openSession(*recordSession, *replaySession); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+			os.Exit(setupExit)
+		}
+	}
+
+	if options.schemaFile != "" && command != configShowCommand.FullCommand() {
+		if options.schema, err = loadSchema(options, options.schemaFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+			os.Exit(setupExit)
+		}
+	}
+
+	if options.resolver == "system" && command != configShowCommand.FullCommand() {
+		if err := checkSystemResolver(options, command); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+			os.Exit(setupExit)
+		}
+	}
+
+	switch command {
+	case getCommand.FullCommand():
+		get(options, *source, *key, *defaultValues)
+	case doctorCommand.FullCommand():
+		doctor(options, *doctorDomain)
+	case waitCommand.FullCommand():
+		wait(options, waitOptions, *waitSource, *waitKey, *waitExpected, *waitSerial)
+	case hashCommand.FullCommand():
+		hash(options, *hashKey, *hashDomain, *hashValues)
+	case flagCommand.FullCommand():
+		featureFlag(options, *flagSource, *flagName, *flagSubject, *flagDefault)
+	case checkCommand.FullCommand():
+		check(options, checkOptions, *checkSource, *checkExpectations)
+	case validateCommand.FullCommand():
+		validate(options, *validateSource)
+	case configShowCommand.FullCommand():
+		if err := showConfig(os.Stdout, kingpin.CommandLine, config, profile, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing settings: %s\n", err.Error())
+			os.Exit(5)
+		}
+	}
+}
+
+// The command that args select, so that errors can be reported the way it needs.  An unknown flag makes kingpin stop
+// parsing and fall back to the default command, so unknown flags are dropped (by where kingpin says they are) until
+// the rest parses.
+func selectedCommand(app *kingpin.Application, args []string) string {
+	for {
+		context, err := app.ParseContext(args)
+		if context == nil {
+			return ""
+		}
+		if err != nil {
+			token := context.Peek()
+			if (token.Type == kingpin.TokenLong || token.Type == kingpin.TokenShort) && token.Index > 0 && token.Index <= len(args) {
+				args = append(args[:token.Index-1:token.Index-1], args[token.Index:]...)
+				continue
+			}
+		}
+		if context.SelectedCommand == nil {
+			return ""
+		}
+		return context.SelectedCommand.FullCommand()
+	}
+}
 
-	if *defaultValues == nil {
-		defaultValues = &[]string{}
+func get(options *options, source string, key string, defaultValues []string) {
+	if defaultValues == nil {
+		defaultValues = []string{}
 	}
 
-	if options.valueType == "single" && len(*defaultValues) > 1 {
-		fmt.Fprintf(os.Stderr, "Got %d default values, but the value type is \"single\".  (Did you mean to set --type list?)\n", len(*defaultValues))
+	if options.valueType == "single" && len(defaultValues) > 1 {
+		fmt.Fprintf(os.Stderr, "Got %d default values, but the value type is \"single\".  (Did you mean to set --type list?)\n", len(defaultValues))
 		os.Exit(1)
 	}
 
-	provider, err := getTxtProvider(options, *source)
+	provider, err := getTxtProvider(options, source)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
 		os.Exit(2)
 	}
+	options.fileSource = isFileSource(options, source)
+	setUpInterpolation(options, source)
 
-	txtRecords, err := provider.getTxtRecords()
+	txtRecords, err := getKeyTxtRecords(provider, key)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error looking up TXT records:\n%+v\n", err.Error())
 		os.Exit(3)
 	}
 
 	var values []string
-	values, err = lookUpValues(options, txtRecords, *key, *defaultValues)
+	values, err = lookUpValues(options, txtRecords, key, defaultValues)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error looking up values for key \"%s\" in %s:\n%+v\n", *key, *source, err.Error())
+		fmt.Fprintf(os.Stderr, "Error looking up values for key \"%s\" in %s:\n%+v\n", key, source, err.Error())
 		os.Exit(4)
 	}
 
@@ -206,3 +637,173 @@ func main() {
 		os.Exit(5)
 	}
 }
+
+func doctor(options *options, domain string) {
+	report := runDoctor(options, domain)
+	if err := outputDoctorReport(options, os.Stdout, report); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %s\n", err.Error())
+		os.Exit(5)
+	}
+	if report.failed() {
+		os.Exit(1)
+	}
+}
+
+func wait(options *options, waitOptions *waitOptions, source string, key string, expected []string, serial string) {
+	if serial == "" && key == "" {
+		fmt.Fprintf(os.Stderr, "Either a key and expected value(s), or --serial, is required\n")
+		os.Exit(1)
+	}
+	if serial == "" && options.valueType == "single" && len(expected) != 1 {
+		fmt.Fprintf(os.Stderr, "Got %d expected values, but the value type is \"single\".  (Did you mean to set --type list?)\n", len(expected))
+		os.Exit(1)
+	}
+
+	provider, err := getTxtProvider(options, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+		os.Exit(2)
+	}
+	dnsProvider, ok := provider.(*dnsProvider)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error setting up client: waiting only works with DNS sources\n")
+		os.Exit(2)
+	}
+
+	var condition waitCondition
+	if serial != "" {
+		minimum, err := strconv.ParseUint(serial, 10, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --serial \"%s\": %s\n", serial, err.Error())
+			os.Exit(1)
+		}
+		zone, err := findZoneCut(options, dnsProvider.nameservers[0], dnsProvider.domain)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding zone for %s:\n%+v\n", dnsProvider.domain, err.Error())
+			os.Exit(3)
+		}
+		condition = waitForSerial(options, zone, uint32(minimum))
+	} else {
+		condition = waitForValues(options, key, expected)
+	}
+
+	servers, recurse, err := waitServers(options, waitOptions, dnsProvider)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	switch runWait(options, waitOptions, dnsProvider, servers, recurse, condition, os.Stderr) {
+	case waitMismatched:
+		os.Exit(6)
+	case waitUnreachable:
+		os.Exit(7)
+	case waitTimedOut:
+		os.Exit(8)
+	}
+}
+
+func hash(options *options, key string, domain string, values []string) {
+	options.layout = "hashed"
+	owner := keyOwner(options, key)
+	if domain != "" {
+		domain, err := idnaToASCII(domain)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error converting internationalized domain name: %s\n", err.Error())
+			os.Exit(1)
+		}
+		owner = keyDomain(options, key, dns.Fqdn(domain))
+	}
+	if len(values) == 0 {
+		fmt.Println(owner)
+		return
+	}
+	for _, value := range values {
+		fmt.Println(hashedZoneRecord(options, owner, value))
+	}
+}
+
+func featureFlag(options *options, source string, name string, subject string, defaultRule string) {
+	if subject == "" {
+		subject, _ = os.Hostname()
+	}
+	options.valueType = "single"
+	var defaultValues []string
+	if defaultRule != "" {
+		defaultValues = []string{defaultRule}
+	}
+
+	provider, err := getTxtProvider(options, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+		os.Exit(2)
+	}
+	options.fileSource = isFileSource(options, source)
+
+	txtRecords, err := getKeyTxtRecords(provider, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error looking up TXT records:\n%+v\n", err.Error())
+		os.Exit(3)
+	}
+
+	values, err := lookUpValues(options, txtRecords, name, defaultValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error looking up flag \"%s\" in %s:\n%+v\n", name, source, err.Error())
+		os.Exit(4)
+	}
+
+	enabled, err := evaluateFlag(options, values[0], name, subject, lookupTime(options))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error evaluating flag \"%s\" for %s: %s\n", name, subject, err.Error())
+		os.Exit(4)
+	}
+	tracef(options, traceSummary, "flag %q is %v for %q (rule %q)", name, enabled, subject, values[0])
+	fmt.Println(enabled)
+	if !enabled {
+		os.Exit(1)
+	}
+}
+
+func check(options *options, checkOptions *checkOptions, source string, args []string) {
+	result := &checkResult{}
+	thresholds, err := makeCheckThresholds(checkOptions)
+	if err != nil {
+		result.raise(checkUnknown, err.Error())
+	}
+	var expectations []expectation
+	for _, arg := range args {
+		expectation, err := parseExpectation(arg)
+		if err != nil {
+			result.raise(checkUnknown, err.Error())
+		}
+		expectations = append(expectations, expectation)
+	}
+	if result.status == checkOK {
+		result = runCheck(options, thresholds, source, expectations)
+	}
+	fmt.Println(result)
+	os.Exit(int(result.status))
+}
+
+func validate(options *options, source string) {
+	provider, err := getTxtProvider(options, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up client: %s\n", err.Error())
+		os.Exit(2)
+	}
+	options.fileSource = isFileSource(options, source)
+
+	violations, err := validateSource(options, provider)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error looking up TXT records:\n%+v\n", err.Error())
+		os.Exit(3)
+	}
+	for _, violation := range violations {
+		fmt.Println(violation)
+	}
+	if len(violations) > 0 {
+		fmt.Printf("%d violation(s) in %s\n", len(violations), source)
+		os.Exit(6)
+	}
+	fmt.Printf("%s matches the schema (%d key(s))\n", source, len(options.schema.keys))
+}
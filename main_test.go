@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var sampleTxtRecords = []string{
@@ -25,6 +27,23 @@ var sampleTxtRecords = []string{
 	"CamelCase=CamelCaseValue",
 	"ALLCAPS=ALLCAPS VALUE",
 	"` key`=with escapes`\t \t=`escaped`value",
+	"Straße=street",
+	"caf\u00e9=precomposed",
+	"ΣΊΣΥΦΟΣ=sisyphus",
+}
+
+// An apex TXT set shared with other users
+var sharedTxtRecords = []string{
+	"v=spf1 -all",
+	"google-site-verification=abc123",
+	"foo=unnamespaced",
+	"myapp:foo=bar",
+	"MyApp:db.host=db1.example.com",
+	"other:foo=baz",
+	"myapp:multival=1",
+	"myapp:multival=2",
+	"myapp:url=http://example.com:8080/",
+	"myapp/foo=slash",
 }
 
 var defaultOptions = makeDefaultOptions()
@@ -40,6 +59,23 @@ var jsonListOptions = &options{
 	outputFormat: "json",
 	valueType:    "list",
 }
+var asciiKeysOptions = &options{
+	outputFormat: "plain",
+	valueType:    "single",
+	keyCompare:   "ascii",
+}
+var namespacedOptions = &options{
+	outputFormat: "plain",
+	valueType:    "single",
+	namespace:    "myapp",
+	delimiter:    ":",
+}
+var slashNamespacedOptions = &options{
+	outputFormat: "plain",
+	valueType:    "single",
+	namespace:    "myapp",
+	delimiter:    "/",
+}
 var zeroListOptions = &options{
 	outputFormat: "zero",
 	valueType:    "list",
@@ -116,6 +152,22 @@ func TestLookUpValues(t *testing.T) {
 		{defaultOptions, sampleTxtRecords, "Allcaps", []string{}, []string{"ALLCAPS VALUE"}, nil},
 		{defaultOptions, sampleTxtRecords, "with tabs\tand spaces", []string{}, []string{"  whitespace value\t "}, nil},
 		{defaultOptions, sampleTxtRecords, " key=with escapes\t", []string{}, []string{"`escaped`value"}, nil},
+		{defaultOptions, sampleTxtRecords, "STRASSE", []string{}, []string{"street"}, nil},
+		{defaultOptions, sampleTxtRecords, "straße", []string{}, []string{"street"}, nil},
+		{defaultOptions, sampleTxtRecords, "cafe\u0301", []string{}, []string{"precomposed"}, nil},
+		{defaultOptions, sampleTxtRecords, "σίσυφος", []string{}, []string{"sisyphus"}, nil},
+		{asciiKeysOptions, sampleTxtRecords, "FOO", []string{}, []string{"bar"}, nil},
+		{asciiKeysOptions, sampleTxtRecords, "straße", []string{}, []string{"street"}, nil},
+		{asciiKeysOptions, sampleTxtRecords, "STRASSE", []string{}, nil, errors.New("No values")},
+		{asciiKeysOptions, sampleTxtRecords, "cafe\u0301", []string{}, nil, errors.New("No values")},
+		{defaultOptions, sharedTxtRecords, "foo", []string{}, []string{"unnamespaced"}, nil},
+		{defaultOptions, sharedTxtRecords, "myapp:foo", []string{}, []string{"bar"}, nil},
+		{namespacedOptions, sharedTxtRecords, "foo", []string{}, []string{"bar"}, nil},
+		{namespacedOptions, sharedTxtRecords, "db.host", []string{}, []string{"db1.example.com"}, nil},
+		{namespacedOptions, sharedTxtRecords, "url", []string{}, []string{"http://example.com:8080/"}, nil},
+		{namespacedOptions, sharedTxtRecords, "v", []string{}, nil, errors.New("No values")},
+		{namespacedOptions, sharedTxtRecords, "multival", []string{}, nil, errors.New("Too many values")},
+		{slashNamespacedOptions, sharedTxtRecords, "foo", []string{}, []string{"slash"}, nil},
 		{plainListOptions, sampleTxtRecords, "multival", []string{}, []string{"1", "2", "3"}, nil},
 		{plainListOptions, sampleTxtRecords, "foo", []string{}, []string{"bar"}, nil},
 		{plainListOptions, sampleTxtRecords, "nosuchkey", []string{}, []string{}, nil},
@@ -138,3 +190,32 @@ func TestLookUpValues(t *testing.T) {
 		}
 	}
 }
+
+func TestSelectedCommand(t *testing.T) {
+	app := kingpin.New("sdget", "")
+	app.Flag("config", "").String()
+	app.Flag("verbose", "").Short('v').Bool()
+	get := app.Command("get", "").Default()
+	get.Arg("source", "").String()
+	get.Arg("key", "").String()
+	check := app.Command("check", "")
+	check.Arg("source", "").String()
+
+	type testPair struct {
+		Args    []string
+		Command string
+	}
+	for _, testPair := range []testPair{
+		{[]string{"check", "example.com"}, "check"},
+		{[]string{"--bogus", "check", "example.com"}, "check"},
+		{[]string{"-x", "check", "example.com"}, "check"},
+		{[]string{"check", "--bogus", "example.com"}, "check"},
+		{[]string{"--config", "check", "get", "example.com", "foo"}, "get"},
+		{[]string{"--config", "check", "example.com", "foo"}, "get"},
+		{[]string{"example.com", "foo"}, "get"},
+	} {
+		if command := selectedCommand(app, testPair.Args); command != testPair.Command {
+			t.Error("Expected", testPair.Command, "but got", command, "for", testPair)
+		}
+	}
+}
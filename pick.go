@@ -0,0 +1,157 @@
+package main
+
+// --pick: choosing one value when a key has several (e.g., a list of mirrors)
+//
+// Strategies:
+// * first: the first value, in the order the records came
+// * random: any value, uniformly
+// * weighted: any value, in proportion to its weight
+// * hash:SUBJECT: rendezvous hashing, so the same subject always gets the same value (and adding or removing a value
+//   only moves the subjects that have to move)
+//
+// With --pick, values can end in ";w=N" to give them weight N (default 1).  The suffix is removed from the output.
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var weightSuffix = regexp.MustCompile(`;w=([0-9]+(\.[0-9]*)?)$`)
+
+type weightedValue struct {
+	value  string
+	weight float64
+}
+
+func parseWeightedValues(values []string) []weightedValue {
+	var results []weightedValue
+	for _, value := range values {
+		weighted := weightedValue{value, 1}
+		if match := weightSuffix.FindStringSubmatchIndex(value); match != nil {
+			// The regexp only matches valid numbers
+			weighted.weight, _ = strconv.ParseFloat(value[match[2]:match[3]], 64)
+			weighted.value = value[:match[0]]
+		}
+		results = append(results, weighted)
+	}
+	return results
+}
+
+// The random number source for --pick random and weighted.  --seed makes picks reproducible.
+func pickRand(options *options) *rand.Rand {
+	if options.seed != nil {
+		return rand.New(rand.NewSource(*options.seed))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// Splits a --pick strategy into its name and any subject, so that mistakes are caught before looking anything up
+func parsePickStrategy(pick string) (strategy string, subject string, err error) {
+	strategy = pick
+	if strings.HasPrefix(strategy, "hash:") {
+		strategy, subject = "hash", strings.TrimPrefix(strategy, "hash:")
+	}
+	switch strategy {
+	case "first", "random", "weighted":
+		return strategy, subject, nil
+	case "hash":
+		if subject == "" {
+			return "", "", errors.New("--pick hash needs a subject (e.g., hash:$HOSTNAME)")
+		}
+		return strategy, subject, nil
+	}
+	return "", "", errors.Errorf("unknown --pick strategy \"%s\" (first, random, weighted or hash:SUBJECT)", pick)
+}
+
+func pickValue(options *options, values []string) (string, error) {
+	strategy, subject, err := parsePickStrategy(options.pick)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "", errors.New("no values to pick from")
+	}
+	weighted := parseWeightedValues(values)
+
+	var total float64
+	for _, value := range weighted {
+		total += value.weight
+	}
+	if total == 0 && (strategy == "weighted" || strategy == "hash") {
+		return "", errors.New("all values have weight 0")
+	}
+
+	switch strategy {
+	case "first":
+		return weighted[0].value, nil
+
+	case "random":
+		return weighted[pickRand(options).Intn(len(weighted))].value, nil
+
+	case "weighted":
+		target := pickRand(options).Float64() * total
+		for _, value := range weighted {
+			if target < value.weight {
+				return value.value, nil
+			}
+			target -= value.weight
+		}
+		// Rounding errors
+		for i := len(weighted) - 1; i >= 0; i-- {
+			if weighted[i].weight > 0 {
+				return weighted[i].value, nil
+			}
+		}
+
+	case "hash":
+		best := -1
+		var bestScore float64
+		for i, value := range weighted {
+			if value.weight == 0 {
+				continue
+			}
+			if score := rendezvousScore(subject, value); best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		return weighted[best].value, nil
+	}
+	return "", errors.Errorf("unknown --pick strategy \"%s\" (first, random, weighted or hash:SUBJECT)", options.pick)
+}
+
+// --seed, which is only used if it's given (so that any seed, including 0, can be reproduced)
+type seedValue struct {
+	seed **int64
+}
+
+func (v *seedValue) Set(value string) error {
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid seed \"%s\"", value)
+	}
+	*v.seed = &seed
+	return nil
+}
+
+func (v *seedValue) String() string {
+	if *v.seed == nil {
+		return ""
+	}
+	return strconv.FormatInt(**v.seed, 10)
+}
+
+// Weighted rendezvous hashing: the value with the highest score wins.  -weight/ln(u), with u uniform in (0, 1), gives
+// each value a chance proportional to its weight.
+func rendezvousScore(subject string, value weightedValue) float64 {
+	sum := sha256.Sum256([]byte(subject + "\000" + value.value))
+	u := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+	return -value.weight / math.Log(u)
+}
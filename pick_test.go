@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var mirrorValues = []string{"a.example.com", "b.example.com;w=3", "c.example.com;w=0", "d.example.com;w=0.5"}
+
+type pickValueTestPair struct {
+	Pick   string
+	Seed   int64
+	Values []string
+	Result string
+	Err    error
+}
+
+func TestPickValue(t *testing.T) {
+	for _, testPair := range []pickValueTestPair{
+		{"first", 0, mirrorValues, "a.example.com", nil},
+		{"first", 0, []string{"x;w=2"}, "x", nil},
+		{"first", 0, []string{"semicolons;are=fine"}, "semicolons;are=fine", nil},
+		{"first", 0, []string{}, "", errors.New("No values")},
+		{"random", 1, []string{"only"}, "only", nil},
+		{"weighted", 1, []string{"zero;w=0", "one"}, "one", nil},
+		{"weighted", 1, []string{"zero;w=0", "zero too;w=0"}, "", errors.New("All zero")},
+		{"hash:", 0, mirrorValues, "", errors.New("No subject")},
+		{"hash:web1", 0, []string{"zero;w=0", "one"}, "one", nil},
+		{"hash:web1", 0, []string{"zero;w=0"}, "", errors.New("All zero")},
+		{"nearest", 0, mirrorValues, "", errors.New("Unknown strategy")},
+	} {
+		options := makeDefaultOptions()
+		options.pick = testPair.Pick
+		seed := testPair.Seed
+		options.seed = &seed
+		result, err := pickValue(options, testPair.Values)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestPickReproducible(t *testing.T) {
+	for _, pick := range []string{"random", "weighted", "hash:web1"} {
+		for _, seed := range []int64{0, 42} {
+			options := makeDefaultOptions()
+			options.pick = pick
+			options.seed = &seed
+			first, err := pickValue(options, mirrorValues)
+			if err != nil {
+				t.Fatal("Error", err.Error())
+			}
+			for i := 0; i < 10; i++ {
+				if again, _ := pickValue(options, mirrorValues); again != first {
+					t.Error("Expected", first, "but got", again, "for", pick, "with seed", seed)
+				}
+			}
+		}
+	}
+}
+
+// Counts how often each value gets picked, varying the seed or subject
+func countPicks(t *testing.T, pick string, values []string) map[string]int {
+	counts := make(map[string]int)
+	for i := 1; i <= 4500; i++ {
+		options := makeDefaultOptions()
+		options.pick = pick
+		seed := int64(i)
+		options.seed = &seed
+		if pick == "hash" {
+			options.pick = fmt.Sprintf("hash:host%d", i)
+		}
+		picked, err := pickValue(options, values)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		counts[picked]++
+	}
+	return counts
+}
+
+func TestPickDistribution(t *testing.T) {
+	values := []string{"a", "b;w=2", "c;w=0"}
+	for _, pick := range []string{"weighted", "hash"} {
+		counts := countPicks(t, pick, values)
+		if counts["c"] != 0 {
+			t.Error("Expected weight 0 value never to be picked but got", counts, "for", pick)
+		}
+		if counts["a"] < 1300 || counts["a"] > 1700 || counts["b"] < 2800 || counts["b"] > 3200 {
+			t.Error("Expected about 1500 a and 3000 b but got", counts, "for", pick)
+		}
+	}
+	counts := countPicks(t, "random", values)
+	for _, value := range []string{"a", "b", "c"} {
+		if counts[value] < 1300 || counts[value] > 1700 {
+			t.Error("Expected about 1500", value, "but got", counts, "for random")
+		}
+	}
+}
+
+func TestPickHashConsistent(t *testing.T) {
+	// Removing a value only moves the subjects that had it
+	before := []string{"a", "b", "c", "d"}
+	after := []string{"a", "b", "d"}
+	for i := 0; i < 200; i++ {
+		options := makeDefaultOptions()
+		options.pick = fmt.Sprintf("hash:host%d", i)
+		oldPick, _ := pickValue(options, before)
+		newPick, _ := pickValue(options, after)
+		if oldPick != "c" && newPick != oldPick {
+			t.Error("Expected", options.pick, "to keep", oldPick, "but got", newPick)
+		}
+	}
+}
+
+func TestLookUpValuesWithPick(t *testing.T) {
+	options := makeDefaultOptions()
+	options.pick = "first"
+	values, err := lookUpValues(options, sampleTxtRecords, "multival", nil)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(values, []string{"1"}) {
+		t.Error("Expected [1] but got", values)
+	}
+	values, err = lookUpValues(options, sampleTxtRecords, "nosuchkey", []string{"x;w=5"})
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !reflect.DeepEqual(values, []string{"x"}) {
+		t.Error("Expected [x] but got", values)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+// Schemas (--schema, sdget validate): what a source's table must look like
+//
+//	{
+//	  "keys": {
+//	    "region": {"required": true, "enum": ["eu", "us"]},
+//	    "port": {"type": "integer", "minimum": 1, "maximum": 65535},
+//	    "mirror": {"cardinality": "list", "pattern": "^[a-z0-9.-]+$"}
+//	  }
+//	}
+//
+// Keys that aren't in the schema can have any values.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+type schema struct {
+	keys []*keySchema // Sorted by name
+}
+
+type keySchema struct {
+	Description string   `json:"description"`
+	Type        string   `json:"type"`        // string (default), integer, number or boolean
+	Required    bool     `json:"required"`    // The key must have at least one value
+	Cardinality string   `json:"cardinality"` // single (default) or list
+	Enum        []string `json:"enum"`
+	Pattern     string   `json:"pattern"`
+	Minimum     *float64 `json:"minimum"`
+	Maximum     *float64 `json:"maximum"`
+
+	name       string
+	normalized string
+	pattern    *regexp.Regexp
+}
+
+// The types, for messages
+var schemaTypes = map[string]string{"string": "a string", "integer": "an integer", "number": "a number", "boolean": "a boolean"}
+
+func loadSchema(options *options, path string) (*schema, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading schema")
+	}
+	defer file.Close()
+	schema, err := parseSchema(options, file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error in schema %s", path)
+	}
+	return schema, nil
+}
+
+func parseSchema(options *options, reader io.Reader) (*schema, error) {
+	var file struct {
+		Keys map[string]*keySchema `json:"keys"`
+	}
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+
+	result := &schema{}
+	seen := make(map[string]string)
+	for name, key := range file.Keys {
+		if key == nil {
+			return nil, errors.Errorf("key %s: expected an object", name)
+		}
+		key.name = name
+		key.normalized = normalizeKey(options, name)
+		if other, ok := seen[key.normalized]; ok {
+			return nil, errors.Errorf("keys %s and %s are the same key", other, name)
+		}
+		seen[key.normalized] = name
+
+		if key.Type == "" {
+			key.Type = "string"
+		}
+		if _, ok := schemaTypes[key.Type]; !ok {
+			return nil, errors.Errorf("key %s: unknown type \"%s\" (expected string, integer, number or boolean)", name, key.Type)
+		}
+		if key.Cardinality == "" {
+			key.Cardinality = "single"
+		}
+		if key.Cardinality != "single" && key.Cardinality != "list" {
+			return nil, errors.Errorf("key %s: unknown cardinality \"%s\" (expected single or list)", name, key.Cardinality)
+		}
+		if key.Pattern != "" {
+			var err error
+			if key.pattern, err = regexp.Compile(key.Pattern); err != nil {
+				return nil, errors.Wrapf(err, "key %s: invalid pattern", name)
+			}
+		}
+		numeric := key.Type == "integer" || key.Type == "number"
+		if (key.Minimum != nil || key.Maximum != nil) && !numeric {
+			return nil, errors.Errorf("key %s: minimum and maximum need type integer or number", name)
+		}
+		if key.Minimum != nil && key.Maximum != nil && *key.Minimum > *key.Maximum {
+			return nil, errors.Errorf("key %s: minimum is more than maximum", name)
+		}
+		result.keys = append(result.keys, key)
+	}
+	sort.Slice(result.keys, func(i, j int) bool { return result.keys[i].name < result.keys[j].name })
+	return result, nil
+}
+
+// The schema for a normalized key, or nil
+func (s *schema) find(key string) *keySchema {
+	for _, keySchema := range s.keys {
+		if keySchema.normalized == key {
+			return keySchema
+		}
+	}
+	return nil
+}
+
+// What's wrong with the stored values of a (normalized) key
+func (s *schema) violations(options *options, key string, values []string) []string {
+	keySchema := s.find(key)
+	if keySchema == nil {
+		return nil
+	}
+	var results []string
+	if len(values) == 0 && keySchema.Required {
+		results = append(results, fmt.Sprintf("%s: required but missing", keySchema.name))
+	}
+	if len(values) > 1 && keySchema.Cardinality == "single" {
+		results = append(results, fmt.Sprintf("%s: %d values, but only 1 is allowed", keySchema.name, len(values)))
+	}
+	for _, value := range values {
+		if problem := keySchema.checkValue(value); problem != "" {
+			results = append(results, fmt.Sprintf("%s: %q %s", keySchema.name, redactValue(options, key, value), problem))
+		}
+	}
+	return results
+}
+
+func (k *keySchema) checkValue(value string) string {
+	var number float64
+	var err error
+	switch k.Type {
+	case "integer":
+		var integer int64
+		integer, err = strconv.ParseInt(value, 10, 64)
+		number = float64(integer)
+	case "number":
+		number, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		if value != "true" && value != "false" {
+			err = errors.New("not a boolean")
+		}
+	}
+	if err != nil {
+		return "isn't " + schemaTypes[k.Type]
+	}
+
+	if len(k.Enum) > 0 {
+		found := false
+		for _, allowed := range k.Enum {
+			found = found || value == allowed
+		}
+		if !found {
+			return fmt.Sprintf("isn't one of %q", k.Enum)
+		}
+	}
+	if k.pattern != nil && !k.pattern.MatchString(value) {
+		return fmt.Sprintf("doesn't match %q", k.Pattern)
+	}
+	if k.Minimum != nil && number < *k.Minimum {
+		return fmt.Sprintf("is less than %v", *k.Minimum)
+	}
+	if k.Maximum != nil && number > *k.Maximum {
+		return fmt.Sprintf("is more than %v", *k.Maximum)
+	}
+	return ""
+}
+
+// Checks every key in the schema against a source, for sdget validate
+func validateSource(options *options, provider txtProvider) ([]string, error) {
+	var results []string
+	fetcher := &keyRecordsFetcher{options: options, provider: provider}
+	for _, keySchema := range options.schema.keys {
+		txtRecords, err := fetcher.recordsFor(keySchema.name)
+		if err != nil {
+			return nil, err
+		}
+		values, err := storedValues(options, txtRecords, keySchema.normalized)
+		if err != nil {
+			results = append(results, fmt.Sprintf("%s: %s", keySchema.name, err.Error()))
+			continue
+		}
+		results = append(results, options.schema.violations(options, keySchema.normalized, values)...)
+	}
+	return results, nil
+}
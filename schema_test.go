@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+  "keys": {
+    "region": {"description": "Where the service runs", "required": true, "enum": ["eu", "us"]},
+    "Port": {"type": "integer", "minimum": 1, "maximum": 65535},
+    "ratio": {"type": "number", "maximum": 1},
+    "debug": {"type": "boolean"},
+    "mirror": {"cardinality": "list", "required": true, "pattern": "^[a-z0-9.-]+$"}
+  }
+}`
+
+type parseSchemaTestPair struct {
+	Input string
+	Keys  []string
+	Err   error
+}
+
+func TestParseSchema(t *testing.T) {
+	for _, testPair := range []parseSchemaTestPair{
+		{testSchema, []string{"Port", "debug", "mirror", "ratio", "region"}, nil},
+		{`{"keys": {}}`, nil, nil},
+		{`{}`, nil, nil},
+		{`{"keys": {"port": {"type": "int"}}}`, nil, errors.New("Unknown type")},
+		{`{"keys": {"port": {"cardinality": "many"}}}`, nil, errors.New("Unknown cardinality")},
+		{`{"keys": {"port": {"pattern": "[0-9"}}}`, nil, errors.New("Bad pattern")},
+		{`{"keys": {"port": {"minimum": 1}}}`, nil, errors.New("Range for a string")},
+		{`{"keys": {"port": {"type": "integer", "minimum": 2, "maximum": 1}}}`, nil, errors.New("Backwards range")},
+		{`{"keys": {"port": {"requried": true}}}`, nil, errors.New("Unknown property")},
+		{`{"keys": {"port": {}, "PORT": {}}}`, nil, errors.New("Repeated key")},
+		{`{"keys": {"port": null}}`, nil, errors.New("Not an object")},
+		{`{"keys": [`, nil, errors.New("Bad JSON")},
+	} {
+		schema, err := parseSchema(makeDefaultOptions(), strings.NewReader(testPair.Input))
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err == nil {
+			var keys []string
+			for _, key := range schema.keys {
+				keys = append(keys, key.name)
+			}
+			if !reflect.DeepEqual(keys, testPair.Keys) {
+				t.Error("Expected", testPair.Keys, "but got", keys, "for", testPair)
+			}
+		}
+	}
+}
+
+type schemaViolationsTestPair struct {
+	Key    string
+	Values []string
+	Result []string
+}
+
+func TestSchemaViolations(t *testing.T) {
+	options := makeDefaultOptions()
+	schema, err := parseSchema(options, strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	for _, testPair := range []schemaViolationsTestPair{
+		{"region", []string{"eu"}, nil},
+		{"region", nil, []string{"region: required but missing"}},
+		{"region", []string{"ap"}, []string{"region: \"ap\" isn't one of [\"eu\" \"us\"]"}},
+		{"region", []string{"eu", "us"}, []string{"region: 2 values, but only 1 is allowed"}},
+		{"port", []string{"8080"}, nil},
+		{"port", nil, nil},
+		{"port", []string{"http"}, []string{"Port: \"http\" isn't an integer"}},
+		{"port", []string{"0"}, []string{"Port: \"0\" is less than 1"}},
+		{"port", []string{"65536"}, []string{"Port: \"65536\" is more than 65535"}},
+		{"ratio", []string{"0.5"}, nil},
+		{"ratio", []string{"1.5"}, []string{"ratio: \"1.5\" is more than 1"}},
+		{"ratio", []string{"half"}, []string{"ratio: \"half\" isn't a number"}},
+		{"debug", []string{"yes"}, []string{"debug: \"yes\" isn't a boolean"}},
+		{"mirror", []string{"a.example.com", "b.example.com"}, nil},
+		{"mirror", []string{"a.example.com", "B.example.com"}, []string{"mirror: \"B.example.com\" doesn't match \"^[a-z0-9.-]+$\""}},
+		{"other", []string{"anything", "at all"}, nil},
+	} {
+		result := schema.violations(options, testPair.Key, testPair.Values)
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestLookUpValuesWithSchema(t *testing.T) {
+	options := makeDefaultOptions()
+	schema, err := parseSchema(options, strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	options.schema = schema
+	txtRecords := []string{"region=eu", "port=http", "ratio=0.5"}
+
+	if values, err := lookUpValues(options, txtRecords, "region", nil); err != nil || !reflect.DeepEqual(values, []string{"eu"}) {
+		t.Error("Expected [eu] but got", values, err)
+	}
+	if _, err := lookUpValues(options, txtRecords, "port", []string{"80"}); err == nil {
+		t.Error("Expected error not caught for a value that isn't an integer")
+	}
+	if _, err := lookUpValues(options, txtRecords, "mirror", []string{"a.example.com"}); err == nil {
+		t.Error("Expected error not caught for a missing required key")
+	}
+	if values, err := lookUpValues(options, txtRecords, "debug", []string{"maybe"}); err != nil || !reflect.DeepEqual(values, []string{"maybe"}) {
+		t.Error("Expected the default [maybe] but got", values, err)
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	address, shutdown := startTestServer(t, `
+example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 300
+config.example.com. 300 IN TXT "region=ap"
+config.example.com. 300 IN TXT "port=8080"
+config.example.com. 300 IN TXT "debug=yes"
+config.example.com. 300 IN TXT "unknown=anything"
+`)
+	defer shutdown()
+
+	options := makeDefaultOptions()
+	options.nameservers = []string{address}
+	schema, err := parseSchema(options, strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	options.schema = schema
+
+	for source, expected := range map[string][]string{
+		"config.example.com": {
+			"debug: \"yes\" isn't a boolean",
+			"mirror: required but missing",
+			"region: \"ap\" isn't one of [\"eu\" \"us\"]",
+		},
+		"missing.example.com": {
+			"mirror: required but missing",
+			"region: required but missing",
+		},
+	} {
+		provider, err := getTxtProvider(options, source)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		result, err := validateSource(options, provider)
+		if err != nil || !reflect.DeepEqual(result, expected) {
+			t.Error("Expected", expected, "but got", result, err, "for", source)
+		}
+	}
+}
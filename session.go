@@ -0,0 +1,193 @@
+package main
+
+// Recording and replaying DNS exchanges (--record-session, --replay-session), for tests that don't depend on real DNS
+//
+// A session file has one JSON object per line for each exchange, in the order they happened:
+//
+//	{"nameserver":"192.0.2.53:53","network":"tcp","question":"config.example.com. IN TXT","recurse":true,...}
+//
+// When replaying, a query is answered by the next recorded exchange with the same question, network, RD and DO bits
+// and nameserver.  If the nameserver isn't in the session (e.g., with another /etc/resolv.conf), any other
+// nameserver's successful exchanges are used.  Once they're used up, the last one is repeated.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+type sessionEntry struct {
+	Nameserver string `json:"nameserver"`
+	Network    string `json:"network"`
+	Question   string `json:"question"`
+	Recurse    bool   `json:"recurse"`
+	DNSSEC     bool   `json:"dnssec"`
+	Query      []byte `json:"query"`              // Wire format (base64 in the file)
+	Response   []byte `json:"response,omitempty"` // Wire format, if there was one
+	Error      string `json:"error,omitempty"`
+	RTT        string `json:"rtt,omitempty"`
+}
+
+type session struct {
+	path  string
+	mutex sync.Mutex
+
+	encoder *json.Encoder // Recording
+
+	// Replaying
+	entries map[string][]*sessionEntry // By sessionKey
+	next    map[string]int             // Index of the next entry to use, by sessionKey and nameserver
+}
+
+func sessionKey(query *dns.Msg, network string) string {
+	entry := makeSessionEntry(query, "", network)
+	return entry.Question + "|" + network + "|" + boolKey(entry.Recurse) + boolKey(entry.DNSSEC)
+}
+
+func boolKey(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func makeSessionEntry(query *dns.Msg, nameserver string, network string) *sessionEntry {
+	entry := &sessionEntry{Nameserver: nameserver, Network: network, Recurse: query.RecursionDesired}
+	if len(query.Question) > 0 {
+		question := query.Question[0]
+		entry.Question = fmt.Sprintf("%s %s %s", strings.ToLower(dns.Fqdn(question.Name)), dns.Class(question.Qclass), dns.Type(question.Qtype))
+	}
+	if opt := query.IsEdns0(); opt != nil {
+		entry.DNSSEC = opt.Do()
+	}
+	return entry
+}
+
+// For --record-session and --replay-session, which can't both be used
+func openSession(recordPath string, replayPath string) (*session, error) {
+	switch {
+	case recordPath != "" && replayPath != "":
+		return nil, errors.New("--record-session and --replay-session can't be used together")
+	case recordPath != "":
+		return createSession(recordPath)
+	case replayPath != "":
+		return loadSession(replayPath)
+	}
+	return nil, nil
+}
+
+func createSession(path string) (*session, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating session file")
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetEscapeHTML(false)
+	return &session{path: path, encoder: encoder}, nil
+}
+
+func loadSession(path string) (*session, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading session file")
+	}
+	defer file.Close()
+
+	result := &session{path: path, entries: make(map[string][]*sessionEntry), next: make(map[string]int)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := &sessionEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, errors.Wrapf(err, "error in session file %s, line %d", path, line)
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(entry.Query); err != nil {
+			return nil, errors.Wrapf(err, "error in session file %s, line %d: invalid query", path, line)
+		}
+		if entry.Response == nil && entry.Error == "" {
+			return nil, errors.Errorf("error in session file %s, line %d: no response or error", path, line)
+		}
+		key := sessionKey(query, entry.Network)
+		result.entries[key] = append(result.entries[key], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error reading session file %s", path)
+	}
+	return result, nil
+}
+
+func (s *session) replaying() bool {
+	return s.entries != nil
+}
+
+func (s *session) record(query *dns.Msg, nameserver string, network string, response *dns.Msg, rtt time.Duration, err error) error {
+	entry := makeSessionEntry(query, nameserver, network)
+	entry.Query, _ = query.Pack()
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		if entry.Response, err = response.Pack(); err != nil {
+			return errors.Wrap(err, "error recording response")
+		}
+		entry.RTT = rtt.String()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return errors.Wrap(s.encoder.Encode(entry), "error writing session file")
+}
+
+func (s *session) replay(options *options, query *dns.Msg, nameserver string, network string) (*dns.Msg, time.Duration, error) {
+	key := sessionKey(query, network)
+	s.mutex.Lock()
+	var candidates []*sessionEntry
+	for _, entry := range s.entries[key] {
+		if entry.Nameserver == nameserver {
+			candidates = append(candidates, entry)
+		}
+	}
+	cursor := key + "|" + nameserver
+	if len(candidates) == 0 {
+		// Errors only happen again with the same nameserver
+		for _, entry := range s.entries[key] {
+			if entry.Error == "" {
+				candidates = append(candidates, entry)
+			}
+		}
+		cursor = key + "|*"
+	}
+	if len(candidates) == 0 {
+		s.mutex.Unlock()
+		question := makeSessionEntry(query, nameserver, network).Question
+		return nil, 0, errors.Errorf("unexpected query for %s to %s (not in session %s)", question, nameserver, s.path)
+	}
+	entry := candidates[len(candidates)-1]
+	if i := s.next[cursor]; i < len(candidates) {
+		entry = candidates[i]
+		s.next[cursor] = i + 1
+	}
+	s.mutex.Unlock()
+
+	tracef(options, traceDetail, "replaying exchange with %s from session %s", entry.Nameserver, s.path)
+	if entry.Error != "" {
+		return nil, 0, errors.New(entry.Error)
+	}
+	response := new(dns.Msg)
+	if err := response.Unpack(entry.Response); err != nil {
+		return nil, 0, errors.Wrapf(err, "invalid response in session %s", s.path)
+	}
+	response.Id = query.Id
+	rtt, _ := time.ParseDuration(entry.RTT)
+	traceResponse(options, nameserver, response, rtt)
+	return response, rtt, nil
+}
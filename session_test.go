@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordAndReplaySession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdget-session")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.jsonl")
+
+	address, shutdown := startTestServer(t, doctorTestZone())
+	options := makeDefaultOptions()
+	// The first nameserver fails, so the session has an error too
+	options.nameservers = []string{"127.0.0.1:1", address}
+	if options.session, err = openSession(path, ""); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	provider, err := getTxtProvider(options, "config.example.com")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	records, err := provider.getTxtRecords()
+	shutdown()
+	if err != nil || !reflect.DeepEqual(records, []string{"foo=bar"}) {
+		t.Fatal("Expected [foo=bar] but got", records, err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"error":`) || !strings.Contains(lines[1], `"question":"config.example.com. IN TXT"`) {
+		t.Error("Unexpected session file", string(contents))
+	}
+
+	// The server is gone, so the answer has to come from the session
+	if options.session, err = openSession("", path); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		records, err = provider.getTxtRecords()
+		if err != nil || !reflect.DeepEqual(records, []string{"foo=bar"}) {
+			t.Error("Expected [foo=bar] from the session but got", records, err)
+		}
+	}
+
+	// Other nameservers get the same answers
+	options.nameservers = []string{"192.0.2.53:53"}
+	if provider, err = getTxtProvider(options, "config.example.com"); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if records, err = provider.getTxtRecords(); err != nil || !reflect.DeepEqual(records, []string{"foo=bar"}) {
+		t.Error("Expected [foo=bar] from the session but got", records, err)
+	}
+
+	if provider, err = getTxtProvider(options, "big.example.com"); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if _, err = provider.getTxtRecords(); err == nil || !strings.Contains(err.Error(), "unexpected query") {
+		t.Error("Expected unexpected query error but got", err)
+	}
+}
+
+func TestReplaySessionOrder(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("Serial.Example.com.", dns.TypeSOA)
+	session := &session{path: "test", entries: make(map[string][]*sessionEntry), next: make(map[string]int)}
+	for _, serial := range []uint32{1, 2} {
+		response := new(dns.Msg)
+		response.SetReply(query)
+		response.Answer = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET}, Ns: "ns1.example.com.", Mbox: "hostmaster.example.com.", Serial: serial}}
+		wire, err := response.Pack()
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		key := sessionKey(query, "tcp")
+		session.entries[key] = append(session.entries[key], &sessionEntry{Nameserver: "192.0.2.53:53", Network: "tcp", Response: wire})
+	}
+
+	options := makeDefaultOptions()
+	lowercase := new(dns.Msg)
+	lowercase.SetQuestion("serial.example.com.", dns.TypeSOA)
+	for _, expected := range []uint32{1, 2, 2} {
+		response, _, err := session.replay(options, lowercase, "192.0.2.53:53", "tcp")
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		if serial := response.Answer[0].(*dns.SOA).Serial; serial != expected || response.Id != lowercase.Id {
+			t.Error("Expected serial", expected, "but got", serial, "with ID", response.Id)
+		}
+	}
+
+	// A different RD bit is a different query
+	lowercase.RecursionDesired = false
+	if _, _, err := session.replay(options, lowercase, "192.0.2.53:53", "tcp"); err == nil {
+		t.Error("Expected error not caught for a query without RD")
+	}
+}
+
+func TestLoadSessionErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdget-session")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	for _, contents := range []string{
+		"not json\n",
+		"{\"network\":\"tcp\",\"query\":\"bm90IGEgcXVlcnk=\",\"error\":\"timeout\"}\n",
+		"{\"network\":\"tcp\",\"query\":\"AAABAAABAAAAAAAAB2V4YW1wbGUDY29tAAAQAAE=\"}\n",
+	} {
+		path := filepath.Join(dir, "session.jsonl")
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		if _, err := loadSession(path); err == nil {
+			t.Error("Expected error not caught for", contents)
+		}
+	}
+	if _, err := openSession(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err == nil {
+		t.Error("Expected error not caught for recording and replaying at once")
+	}
+}
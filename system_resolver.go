@@ -0,0 +1,72 @@
+package main
+
+// --resolver system: lookups through the host's resolver (Go's net.Resolver) instead of sdget's own DNS client, so
+// that things like systemd-resolved's per-link routing (VPN split DNS) apply
+//
+// The resolver only gives back the TXT strings, so some things can't work:
+// * TTLs, DNSSEC flags and signatures
+// * rcodes: an empty answer looks like NXDOMAIN, and other failures are just errors
+// * record types other than TXT, and classes other than IN
+// * choosing nameservers (--nameserver, URI authorities, --authoritative, --race), TSIG and sessions
+// * sdget wait and doctor, which need to talk to particular nameservers
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// The host's resolver (replaced in tests)
+var systemResolver = net.DefaultResolver
+
+// Commands that don't work without sdget's own DNS client
+var builtinResolverCommands = map[string]bool{"doctor": true, "wait": true}
+
+// Refuses options that need sdget's own DNS client
+func checkSystemResolver(options *options, command string) error {
+	var unavailable string
+	switch {
+	case builtinResolverCommands[command]:
+		unavailable = "sdget " + command
+	case len(options.nameservers) > 0:
+		unavailable = "--nameserver"
+	case options.authoritative:
+		unavailable = "--authoritative"
+	case options.race > 1:
+		unavailable = "--race"
+	case options.tsigKeyFile != "":
+		unavailable = "--tsig-key"
+	case options.session != nil:
+		unavailable = "--record-session or --replay-session"
+	default:
+		return nil
+	}
+	return errors.Errorf("%s isn't available with --resolver system", unavailable)
+}
+
+func checkSystemQuery(nameserver string, qclass uint16, qtype uint16) error {
+	if nameserver != "" {
+		return errors.Errorf("nameserver %s can't be used with --resolver system", nameserver)
+	}
+	if qclass != dns.ClassINET || qtype != dns.TypeTXT {
+		return errors.Errorf("only TXT records in class IN can be looked up with --resolver system, not %s %s", dns.Class(qclass), dns.Type(qtype))
+	}
+	return nil
+}
+
+func systemLookupTXT(options *options, domain string) ([]string, error) {
+	tracef(options, traceSummary, "looking up %s with the system resolver", domain)
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout(options))
+	defer cancel()
+	records, err := systemResolver.LookupTXT(ctx, domain)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil, &noRecordsError{dns.TypeTXT, domain}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error from system resolver")
+	}
+	tracef(options, traceSummary, "system resolver returned %d record(s)", len(records))
+	return records, nil
+}
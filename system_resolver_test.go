@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// Points systemResolver at a test server, returning a function to undo it
+func useTestSystemResolver(address string) (restore func()) {
+	saved := systemResolver
+	systemResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+	return func() { systemResolver = saved }
+}
+
+func TestSystemResolver(t *testing.T) {
+	address, shutdown := startTestServer(t, doctorTestZone()+perNameTestZone)
+	defer shutdown()
+	defer useTestSystemResolver(address)()
+
+	options := makeDefaultOptions()
+	options.resolver = "system"
+	for _, source := range []string{"config.example.com", "dns:config.example.com"} {
+		provider, err := getTxtProvider(options, source)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		records, err := provider.getTxtRecords()
+		if err != nil || !reflect.DeepEqual(records, []string{"foo=bar"}) {
+			t.Error("Expected [foo=bar] but got", records, err, "for", source)
+		}
+	}
+
+	options.layout = "per-name"
+	provider, err := getTxtProvider(options, "example.com")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	records, err := getKeyTxtRecords(provider, "db.port")
+	if err != nil || !reflect.DeepEqual(records, []string{"db.port=5432"}) {
+		t.Error("Expected [db.port=5432] but got", records, err)
+	}
+
+	options.layout = "rrset"
+	for _, source := range []string{"missing.example.com", "ns1.example.com"} {
+		if provider, err = getTxtProvider(options, source); err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		if _, err = provider.getTxtRecords(); err == nil {
+			t.Error("Expected error not caught for", source)
+		} else if _, missing := errors.Cause(err).(*noRecordsError); !missing {
+			t.Error("Expected no records error but got", err, "for", source)
+		}
+	}
+}
+
+func TestSystemResolverUnavailable(t *testing.T) {
+	options := makeDefaultOptions()
+	options.resolver = "system"
+	for _, source := range []string{"dns://192.0.2.53/config.example.com", "dns:config.example.com?type=SPF", "dns:config.example.com?class=CH"} {
+		if _, err := getTxtProvider(options, source); err == nil || !strings.Contains(err.Error(), "--resolver system") {
+			t.Error("Expected --resolver system error but got", err, "for", source)
+		}
+	}
+
+	if err := checkSystemResolver(options, "get"); err != nil {
+		t.Error("Unexpected error", err.Error())
+	}
+	if err := checkSystemResolver(options, "wait"); err == nil {
+		t.Error("Expected error not caught for sdget wait")
+	}
+	options.nameservers = []string{"192.0.2.53"}
+	if err := checkSystemResolver(options, "get"); err == nil || !strings.Contains(err.Error(), "--nameserver") {
+		t.Error("Expected --nameserver error but got", err)
+	}
+	options.nameservers = nil
+	options.race = 2
+	if err := checkSystemResolver(options, "get"); err == nil || !strings.Contains(err.Error(), "--race") {
+		t.Error("Expected --race error but got", err)
+	}
+
+	options.race = 1
+	thresholds := &checkThresholds{ttlWarning: &thresholdRange{end: 300}}
+	if result := runCheck(options, thresholds, "config.example.com", nil); result.status != checkUnknown {
+		t.Error("Expected UNKNOWN but got", result.status)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+// Diagnostic output for --verbose
+// Everything goes to stderr (options.traceSink) so that stdout stays usable in scripts.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Verbosity levels
+const (
+	traceSummary = 1 // Nameserver choice, response summary, matched values
+	traceDetail  = 2 // Full DNS messages and how every record was parsed
+)
+
+func tracing(options *options, level int) bool {
+	return options.traceSink != nil && options.verbosity >= level
+}
+
+// Racing nameservers means tracing from several goroutines
+var traceLock sync.Mutex
+
+func tracef(options *options, level int, format string, args ...interface{}) {
+	if !tracing(options, level) {
+		return
+	}
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	fmt.Fprintf(options.traceSink, ";; "+format+"\n", args...)
+}
+
+// Warnings are printed even without --verbose
+func warnf(options *options, format string, args ...interface{}) {
+	if options.traceSink == nil {
+		return
+	}
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	fmt.Fprintf(options.traceSink, "Warning: "+format+"\n", args...)
+}
+
+func isSecretKey(options *options, key string) bool {
+	key = normalizeKey(options, key)
+	for _, secret := range options.secretKeys {
+		if normalizeKey(options, secret) == key {
+			return true
+		}
+	}
+	return false
+}
+
+const redacted = "[redacted]"
+
+func redactValue(options *options, key string, value string) string {
+	if isSecretKey(options, key) {
+		return redacted
+	}
+	return value
+}
+
+// Replaces the value part of a record if the key is secret, keeping the key exactly as written
+// Records in the --namespace are checked without their prefix, because that's how the keys get looked up.
+func redactRecord(options *options, record string) string {
+	isRecord, key, value := splitRecord(record)
+	if options.namespace != "" {
+		if stripped, ok := stripNamespace(options, key); ok {
+			key = stripped
+		}
+	}
+	if !isRecord || !isSecretKey(options, key) {
+		return record
+	}
+	return strings.TrimSuffix(record, value) + redacted
+}
+
+func traceQuery(options *options, nameserver string, query *dns.Msg) {
+	if !tracing(options, traceDetail) {
+		return
+	}
+	tracef(options, traceDetail, "query to %s:\n%s", nameserver, query.String())
+}
+
+func traceResponse(options *options, nameserver string, response *dns.Msg, rtt time.Duration) {
+	if !tracing(options, traceSummary) {
+		return
+	}
+	tracef(options, traceSummary, "response from %s in %v: %s, flags:%s%s", nameserver, rtt, dns.RcodeToString[response.Rcode], headerFlags(&response.MsgHdr), nsidSuffix(response))
+	if tracing(options, traceDetail) {
+		tracef(options, traceDetail, "response:\n%s", redactMsg(options, response).String())
+	}
+}
+
+func headerFlags(h *dns.MsgHdr) string {
+	var flags string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{h.Response, "qr"},
+		{h.Authoritative, "aa"},
+		{h.Truncated, "tc"},
+		{h.RecursionDesired, "rd"},
+		{h.RecursionAvailable, "ra"},
+		{h.AuthenticatedData, "ad"},
+		{h.CheckingDisabled, "cd"},
+	} {
+		if flag.set {
+			flags += " " + flag.name
+		}
+	}
+	return flags
+}
+
+// NSID (RFC 5001) identifies which server in an anycast or load-balanced group answered
+func nsidSuffix(response *dns.Msg) string {
+	opt := response.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, option := range opt.Option {
+		if nsid, ok := option.(*dns.EDNS0_NSID); ok {
+			raw, err := hex.DecodeString(nsid.Nsid)
+			if err != nil {
+				return ", NSID: " + nsid.Nsid
+			}
+			return fmt.Sprintf(", NSID: %s (%q)", nsid.Nsid, raw)
+		}
+	}
+	return ""
+}
+
+// Asks the server for its NSID.  Only done when tracing, because some old servers don't cope well with EDNS.
+func requestNsid(options *options, query *dns.Msg) {
+	if !tracing(options, traceSummary) || query.IsEdns0() != nil {
+		return
+	}
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+}
+
+// Copy of a response with the values of secret keys hidden
+func redactMsg(options *options, response *dns.Msg) *dns.Msg {
+	if len(options.secretKeys) == 0 {
+		return response
+	}
+	result := response.Copy()
+	for _, rr := range result.Answer {
+		if !isStringType(rr.Header().Rrtype) {
+			continue
+		}
+		record, err := recordString(rr)
+		if err != nil {
+			continue
+		}
+		redactedRecord := redactRecord(options, record)
+		if redactedRecord == record {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.TXT:
+			rr.Txt = []string{miekgQuoteTxt(redactedRecord)}
+		case *dns.SPF:
+			rr.Txt = []string{miekgQuoteTxt(redactedRecord)}
+		case *dns.AVC:
+			rr.Txt = []string{miekgQuoteTxt(redactedRecord)}
+		case *dns.URI:
+			rr.Target = redactedRecord
+		}
+	}
+	return result
+}
+
+func traceRecords(options *options, records []string) {
+	if !tracing(options, traceDetail) {
+		return
+	}
+	for _, record := range records {
+		isRecord, key, value := splitRecord(record)
+		if !isRecord {
+			tracef(options, traceDetail, "ignoring %q: not a key/value pair", record)
+			continue
+		}
+		tracef(options, traceDetail, "parsed %q: key %q, value %q", redactRecord(options, record), key, redactValue(options, key, value))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const traceTestZone = `
+config.example.com. 300 IN TXT "foo=bar"
+config.example.com. 300 IN TXT "Password=hunter2"
+config.example.com. 300 IN TXT "not a key/value pair"
+`
+
+func TestTraceLookup(t *testing.T) {
+	address, shutdown := startTestServer(t, traceTestZone)
+	defer shutdown()
+
+	var traceBuffer bytes.Buffer
+	options := makeDefaultOptions()
+	options.verbosity = traceDetail
+	options.secretKeys = []string{"PASSWORD"}
+	options.traceSink = &traceBuffer
+
+	provider, err := makeDnsProvider(options, address, "config.example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	records, err := provider.getTxtRecords()
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if _, err := lookUpValues(options, records, "foo", nil); err != nil {
+		t.Error("Error", err.Error())
+	}
+
+	trace := traceBuffer.String()
+	for _, expected := range []string{
+		"using nameserver " + address + " from URI authority",
+		"query to " + address,
+		"response from " + address,
+		"NOERROR, flags: qr aa",
+		`Password=[redacted]`,
+		`ignoring "not a key/value pair"`,
+		`key "foo" matched value "bar"`,
+	} {
+		if !strings.Contains(trace, expected) {
+			t.Error("Expected", expected, "in trace output:\n", trace)
+		}
+	}
+	if strings.Contains(trace, "hunter2") {
+		t.Error("Secret value leaked into trace output:\n", trace)
+	}
+}
+
+func TestTraceDisabled(t *testing.T) {
+	var traceBuffer bytes.Buffer
+	options := makeDefaultOptions()
+	options.traceSink = &traceBuffer
+
+	if _, err := lookUpValues(options, sampleTxtRecords, "foo", nil); err != nil {
+		t.Error("Error", err.Error())
+	}
+	if traceBuffer.Len() != 0 {
+		t.Error("Expected no trace output but got:\n", traceBuffer.String())
+	}
+}
+
+type redactRecordTestPair struct {
+	Input  string
+	Result string
+}
+
+func TestRedactRecord(t *testing.T) {
+	options := makeDefaultOptions()
+	options.secretKeys = []string{"token", "API Key"}
+	for _, testPair := range []redactRecordTestPair{
+		{"foo=bar", "foo=bar"},
+		{"token=abc", "token=[redacted]"},
+		{" TOKEN =abc=def", " TOKEN =[redacted]"},
+		{"api key=", "api key=[redacted]"},
+		{"token", "token"},
+		{"token`=x=abc", "token`=x=abc"},
+	} {
+		result := redactRecord(options, testPair.Input)
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestRedactNamespacedRecord(t *testing.T) {
+	options := makeDefaultOptions()
+	options.secretKeys = []string{"token"}
+	options.namespace = "myapp"
+	for _, testPair := range []redactRecordTestPair{
+		{"token=abc", "token=[redacted]"},
+		{"myapp:token=abc", "myapp:token=[redacted]"},
+		{"MYAPP:Token=abc", "MYAPP:Token=[redacted]"},
+		{"other:token=abc", "other:token=abc"},
+		{"myapp:foo=bar", "myapp:foo=bar"},
+	} {
+		result := redactRecord(options, testPair.Input)
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestRedactMsg(t *testing.T) {
+	options := makeDefaultOptions()
+	options.secretKeys = []string{"token"}
+	response := new(dns.Msg)
+	for _, rr := range []string{
+		`config.example.com. 300 IN TXT "token=abc"`,
+		`config.example.com. 300 IN SPF "token=abc"`,
+		`config.example.com. 300 IN URI 10 1 "token=abc"`,
+		`config.example.com. 300 IN URI 10 1 "foo=bar"`,
+	} {
+		parsed, err := dns.NewRR(rr)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		response.Answer = append(response.Answer, parsed)
+	}
+	result := redactMsg(options, response).String()
+	if strings.Contains(result, "abc") {
+		t.Error("Secret value leaked into message:\n", result)
+	}
+	if !strings.Contains(result, "foo=bar") {
+		t.Error("Expected foo=bar in message:\n", result)
+	}
+	if !strings.Contains(response.String(), "token=abc") {
+		t.Error("Original message was modified:\n", response.String())
+	}
+}
+
+func TestMiekgQuoteTxt(t *testing.T) {
+	for _, input := range []string{"", "foo", `"foo" \bar\`, "tab\tspaced", "I ♡ unicode", "\x7f\x00"} {
+		quoted := miekgQuoteTxt(input)
+		result, err := miekgUnquoteTxt(quoted)
+		if err != nil {
+			t.Error("Error", err.Error(), "for", input)
+		}
+		if result != input {
+			t.Error("Expected", input, "but got", result, "via", quoted)
+		}
+	}
+}
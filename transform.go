@@ -0,0 +1,161 @@
+package main
+
+// Post-processing of looked-up values (--split, --trim, --extract, --unique, --sort, --limit)
+//
+// Transforms are applied in the order they're given on the command line, before the check for --type single, so
+// splitting one value can make a list.
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type transform struct {
+	flag     string
+	argument string // Empty for boolean flags
+	apply    func(values []string) []string
+}
+
+func (t transform) String() string {
+	if t.argument == "" {
+		return t.flag
+	}
+	return t.flag + " " + strconv.Quote(t.argument)
+}
+
+func applyTransforms(options *options, values []string) []string {
+	for _, t := range options.transforms {
+		values = t.apply(values)
+		tracef(options, traceSummary, "%d value(s) after %s", len(values), t)
+	}
+	return values
+}
+
+func splitTransform(separator string) (func([]string) []string, error) {
+	if separator == "" {
+		return nil, errors.New("separator can't be empty")
+	}
+	return func(values []string) []string {
+		var results []string
+		for _, value := range values {
+			results = append(results, strings.Split(value, separator)...)
+		}
+		return results
+	}, nil
+}
+
+func trimTransform(string) (func([]string) []string, error) {
+	return func(values []string) []string {
+		var results []string
+		for _, value := range values {
+			results = append(results, strings.TrimSpace(value))
+		}
+		return results
+	}, nil
+}
+
+// Values that don't match are dropped
+func extractTransform(pattern string) (func([]string) []string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() != 1 {
+		return nil, errors.Errorf("expected exactly one group in regular expression, but got %d", re.NumSubexp())
+	}
+	return func(values []string) []string {
+		var results []string
+		for _, value := range values {
+			if match := re.FindStringSubmatch(value); match != nil {
+				results = append(results, match[1])
+			}
+		}
+		return results
+	}, nil
+}
+
+// Keeps the first of any duplicates
+func uniqueTransform(string) (func([]string) []string, error) {
+	return func(values []string) []string {
+		var results []string
+		seen := make(map[string]bool)
+		for _, value := range values {
+			if !seen[value] {
+				seen[value] = true
+				results = append(results, value)
+			}
+		}
+		return results
+	}, nil
+}
+
+func sortTransform(string) (func([]string) []string, error) {
+	return func(values []string) []string {
+		results := append([]string{}, values...)
+		sort.Strings(results)
+		return results
+	}, nil
+}
+
+func limitTransform(limit string) (func([]string) []string, error) {
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 0 {
+		return nil, errors.Errorf("invalid limit \"%s\"", limit)
+	}
+	return func(values []string) []string {
+		if len(values) > n {
+			return values[:n]
+		}
+		return values
+	}, nil
+}
+
+// kingpin flag value that adds a transform to the list each time the flag is used, so they keep their order
+type transformValue struct {
+	transforms *[]transform
+	name       string
+	make       func(argument string) (func([]string) []string, error)
+	boolean    bool
+}
+
+func (v *transformValue) Set(argument string) error {
+	if v.boolean && argument == "false" {
+		return nil
+	}
+	apply, err := v.make(argument)
+	if err != nil {
+		return err
+	}
+	if v.boolean {
+		argument = ""
+	}
+	*v.transforms = append(*v.transforms, transform{v.name, argument, apply})
+	return nil
+}
+
+// The arguments of this flag's transforms, for sdget config show
+func (v *transformValue) String() string {
+	var arguments []string
+	for _, t := range *v.transforms {
+		if t.flag != v.name {
+			continue
+		}
+		if v.boolean {
+			return "true"
+		}
+		arguments = append(arguments, t.argument)
+	}
+	return strings.Join(arguments, ",")
+}
+
+func (v *transformValue) IsBoolFlag() bool {
+	return v.boolean
+}
+
+func (v *transformValue) IsCumulative() bool {
+	return true
+}
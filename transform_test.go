@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var transformMakers = map[string]func(string) (func([]string) []string, error){
+	"split":   splitTransform,
+	"trim":    trimTransform,
+	"extract": extractTransform,
+	"unique":  uniqueTransform,
+	"sort":    sortTransform,
+	"limit":   limitTransform,
+}
+
+var booleanTransforms = map[string]bool{"trim": true, "unique": true, "sort": true}
+
+// Sets up transforms as if from the command line, e.g., {"split", ",", "trim", ""}
+func setTransforms(options *options, flags []string) error {
+	for i := 0; i < len(flags); i += 2 {
+		value := &transformValue{&options.transforms, "--" + flags[i], transformMakers[flags[i]], booleanTransforms[flags[i]]}
+		argument := flags[i+1]
+		if value.boolean && argument == "" {
+			argument = "true"
+		}
+		if err := value.Set(argument); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type transformTestPair struct {
+	Flags  []string
+	Values []string
+	Result []string
+	Err    error
+}
+
+func TestApplyTransforms(t *testing.T) {
+	for _, testPair := range []transformTestPair{
+		{[]string{}, []string{"a, b"}, []string{"a, b"}, nil},
+		{[]string{"split", ","}, []string{"a, b,,c"}, []string{"a", " b", "", "c"}, nil},
+		{[]string{"split", ",", "trim", ""}, []string{"a, b", " c "}, []string{"a", "b", "c"}, nil},
+		{[]string{"split", "::"}, []string{"a::b:c"}, []string{"a", "b:c"}, nil},
+		{[]string{"trim", ""}, []string{" \ta\n"}, []string{"a"}, nil},
+		{[]string{"trim", "false"}, []string{" a "}, []string{" a "}, nil},
+		{[]string{"extract", `^v(\d+)`}, []string{"v12-beta", "latest", "v3"}, []string{"12", "3"}, nil},
+		{[]string{"extract", `port=(\d*)`}, []string{"port="}, []string{""}, nil},
+		{[]string{"unique", ""}, []string{"b", "a", "b", "c", "a"}, []string{"b", "a", "c"}, nil},
+		{[]string{"sort", ""}, []string{"b", "c", "a"}, []string{"a", "b", "c"}, nil},
+		{[]string{"limit", "2"}, []string{"a", "b", "c"}, []string{"a", "b"}, nil},
+		{[]string{"limit", "5"}, []string{"a", "b"}, []string{"a", "b"}, nil},
+		{[]string{"limit", "0"}, []string{"a", "b"}, []string{}, nil},
+		{[]string{"split", ",", "sort", "", "limit", "1"}, []string{"c,b,a"}, []string{"a"}, nil},
+		{[]string{"split", ",", "limit", "1", "sort", ""}, []string{"c,b,a"}, []string{"c"}, nil},
+		{[]string{"sort", "", "unique", "", "split", ","}, []string{"b,a", "a", "b,a"}, []string{"a", "b", "a"}, nil},
+		{[]string{"split", ""}, nil, nil, errors.New("Empty separator")},
+		{[]string{"extract", `v\d+`}, nil, nil, errors.New("No group")},
+		{[]string{"extract", `(a)(b)`}, nil, nil, errors.New("Two groups")},
+		{[]string{"extract", `(`}, nil, nil, errors.New("Bad regex")},
+		{[]string{"limit", "-1"}, nil, nil, errors.New("Negative limit")},
+		{[]string{"limit", "few"}, nil, nil, errors.New("Not a number")},
+	} {
+		options := makeDefaultOptions()
+		err := setTransforms(options, testPair.Flags)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		result := applyTransforms(options, testPair.Values)
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+type transformLookUpTestPair struct {
+	Flags     []string
+	ValueType string
+	Key       string
+	Default   []string
+	Result    []string
+	Err       error
+}
+
+func TestLookUpValuesWithTransforms(t *testing.T) {
+	txtRecords := []string{
+		"hosts=web1.example.com, web2.example.com",
+		"version=release-2.4.1",
+		"tags=b",
+		"tags=a",
+		"tags=b",
+	}
+	for _, testPair := range []transformLookUpTestPair{
+		{[]string{"split", ","}, "single", "hosts", nil, nil, errors.New("2 values")},
+		{[]string{"split", ",", "trim", ""}, "list", "hosts", nil, []string{"web1.example.com", "web2.example.com"}, nil},
+		{[]string{"split", ",", "limit", "1"}, "single", "hosts", nil, []string{"web1.example.com"}, nil},
+		{[]string{"extract", `-(\d+)\.`}, "single", "version", nil, []string{"2"}, nil},
+		{[]string{"extract", `^v(\d+)`}, "single", "version", nil, nil, errors.New("No match")},
+		{[]string{"unique", ""}, "list", "tags", nil, []string{"b", "a"}, nil},
+		{[]string{"unique", "", "sort", ""}, "list", "tags", nil, []string{"a", "b"}, nil},
+		{[]string{"split", ",", "trim", ""}, "list", "missing", []string{"x, y"}, []string{"x", "y"}, nil},
+	} {
+		options := makeDefaultOptions()
+		options.valueType = testPair.ValueType
+		if err := setTransforms(options, testPair.Flags); err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		result, err := lookUpValues(options, txtRecords, testPair.Key, testPair.Default)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err == nil && !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
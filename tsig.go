@@ -0,0 +1,98 @@
+package main
+
+// --tsig-key: signing queries with a shared secret (https://tools.ietf.org/html/rfc8945), for nameservers that only
+// answer clients with the key
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// How far apart our clock and the nameserver's can be (the usual default)
+const tsigFudge = 300
+
+type tsigKey struct {
+	name      string // Lowercase FQDN, as the miekg/dns client expects
+	algorithm string
+	secret    string // Base64
+}
+
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":                 dns.HmacMD5,
+	"hmac-md5.sig-alg.reg.int": dns.HmacMD5,
+	"hmac-sha1":                dns.HmacSHA1,
+	"hmac-sha256":              dns.HmacSHA256,
+	"hmac-sha512":              dns.HmacSHA512,
+}
+
+var (
+	bindKeyPattern       = regexp.MustCompile(`(?s)key\s+"?([^"\s{]+)"?\s*\{(.*?)\}\s*;`)
+	bindAlgorithmPattern = regexp.MustCompile(`algorithm\s+"?([^"\s;]+)"?\s*;`)
+	bindSecretPattern    = regexp.MustCompile(`secret\s+"([^"]+)"\s*;`)
+	bindCommentPattern   = regexp.MustCompile(`(?m)(#|//).*$`)
+)
+
+func makeTsigKey(name string, algorithm string, secret string) (*tsigKey, error) {
+	if name == "" {
+		return nil, errors.New("missing key name")
+	}
+	canonical, ok := tsigAlgorithms[strings.TrimSuffix(strings.ToLower(algorithm), ".")]
+	if !ok {
+		return nil, errors.Errorf("unsupported algorithm \"%s\"", algorithm)
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil || secret == "" {
+		return nil, errors.New("secret isn't valid base64")
+	}
+	return &tsigKey{dns.Fqdn(strings.ToLower(name)), canonical, secret}, nil
+}
+
+// Parses a key in BIND's format (as made by tsig-keygen), or dig's -y format ([ALGORITHM:]NAME:SECRET, where the
+// algorithm defaults to hmac-sha256)
+func parseTsigKey(text string) (*tsigKey, error) {
+	text = bindCommentPattern.ReplaceAllString(text, "")
+	if match := bindKeyPattern.FindStringSubmatch(text); match != nil {
+		algorithm := bindAlgorithmPattern.FindStringSubmatch(match[2])
+		if algorithm == nil {
+			return nil, errors.Errorf("missing algorithm for key \"%s\"", match[1])
+		}
+		secret := bindSecretPattern.FindStringSubmatch(match[2])
+		if secret == nil {
+			return nil, errors.Errorf("missing secret for key \"%s\"", match[1])
+		}
+		return makeTsigKey(match[1], algorithm[1], secret[1])
+	}
+
+	parts := strings.Split(strings.TrimSpace(text), ":")
+	switch len(parts) {
+	case 2:
+		return makeTsigKey(parts[0], "hmac-sha256", parts[1])
+	case 3:
+		return makeTsigKey(parts[1], parts[0], parts[2])
+	}
+	return nil, errors.New("expected a BIND key statement or ALGORITHM:NAME:SECRET")
+}
+
+func loadTsigKey(path string) (*tsigKey, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseTsigKey(string(text))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading TSIG key %s", path)
+	}
+	return key, nil
+}
+
+// Has to be done last, after any EDNS0 options are added
+func signQuery(options *options, query *dns.Msg) {
+	if options.tsig != nil {
+		query.SetTsig(options.tsig.name, options.tsig.algorithm, tsigFudge, time.Now().Unix())
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type parseTsigKeyTestPair struct {
+	Input     string
+	Name      string
+	Algorithm string
+	Err       error
+}
+
+func TestParseTsigKey(t *testing.T) {
+	for _, testPair := range []parseTsigKeyTestPair{
+		{"key \"billing.example.com\" {\n\talgorithm hmac-sha256;\n\tsecret \"c2VjcmV0\";\n};\n", "billing.example.com.", dns.HmacSHA256, nil},
+		{"# made by tsig-keygen\nkey Billing { algorithm \"HMAC-SHA512\"; secret \"c2VjcmV0\"; };", "billing.", dns.HmacSHA512, nil},
+		{"key \"billing\" {\n\tsecret \"c2VjcmV0\"; // the secret\n\talgorithm hmac-md5.sig-alg.reg.int.;\n};", "billing.", dns.HmacMD5, nil},
+		{"hmac-sha1:billing.example.com:c2VjcmV0\n", "billing.example.com.", dns.HmacSHA1, nil},
+		{"billing.example.com:c2VjcmV0", "billing.example.com.", dns.HmacSHA256, nil},
+		{"key \"billing\" { secret \"c2VjcmV0\"; };", "", "", errors.New("No algorithm")},
+		{"key \"billing\" { algorithm hmac-sha256; };", "", "", errors.New("No secret")},
+		{"hmac-sha384:billing:c2VjcmV0", "", "", errors.New("Unsupported algorithm")},
+		{"hmac-sha256:billing:not base64!", "", "", errors.New("Bad secret")},
+		{"hmac-sha256::c2VjcmV0", "", "", errors.New("No name")},
+		{"c2VjcmV0", "", "", errors.New("No name")},
+		{"", "", "", errors.New("Empty")},
+	} {
+		key, err := parseTsigKey(testPair.Input)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err == nil && (key.name != testPair.Name || key.algorithm != testPair.Algorithm || key.secret != "c2VjcmV0") {
+			t.Error("Expected", testPair.Name, testPair.Algorithm, "but got", key, "for", testPair)
+		}
+	}
+}
+
+// A nameserver that only answers queries signed with the key
+func startTsigTestServer(t *testing.T, key *tsigKey) (address string, shutdown func()) {
+	var records []dns.RR
+	for token := range dns.ParseZone(strings.NewReader(doctorTestZone()), "", "") {
+		if token.Error != nil {
+			t.Fatal("Error", token.Error.Error(), "parsing test zone")
+		}
+		records = append(records, token.RR)
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+		if query.IsTsig() == nil || w.TsigStatus() != nil {
+			response := new(dns.Msg)
+			response.SetRcode(query, dns.RcodeNotAuth)
+			w.WriteMsg(response)
+			return
+		}
+		response := testServerResponse(records, query, "tcp")
+		response.SetTsig(key.name, key.algorithm, tsigFudge, time.Now().Unix())
+		w.WriteMsg(response)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error", err.Error(), "starting test server")
+	}
+	server := &dns.Server{Listener: listener, Handler: handler, TsigSecret: map[string]string{key.name: key.secret}}
+	go server.ActivateAndServe()
+	return listener.Addr().String(), func() {
+		server.Shutdown()
+	}
+}
+
+func TestSignedQuery(t *testing.T) {
+	serverKey, err := makeTsigKey("billing.example.com", "hmac-sha256", "c2VjcmV0")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	address, shutdown := startTsigTestServer(t, serverKey)
+	defer shutdown()
+
+	failing, shutdownFailing := startTestServerWith(t, doctorTestZone(), func(response *dns.Msg) {
+		response.Rcode = dns.RcodeServerFailure
+	})
+	defer shutdownFailing()
+
+	wrongKey, err := makeTsigKey("billing.example.com", "hmac-sha256", "d3Jvbmc=")
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+
+	for _, key := range []*tsigKey{serverKey, nil, wrongKey} {
+		options := makeDefaultOptions()
+		options.tsig = key
+		// The same query goes to both nameservers, so it has to be signed for each
+		options.nameservers = []string{failing, address}
+		provider, err := makeDnsProvider(options, "", "config.example.com", dns.ClassINET, dns.TypeTXT)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+		records, err := provider.getTxtRecords()
+
+		if key == serverKey {
+			if err != nil {
+				t.Error("Unexpected error", err.Error(), "with the server's key")
+			} else if !reflect.DeepEqual(records, []string{"foo=bar"}) {
+				t.Error("Expected [foo=bar] but got", records)
+			}
+		} else if err == nil {
+			t.Error("Expected error not caught with key", key)
+		}
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 )
 
@@ -45,3 +48,90 @@ func parseURI(uri string) (*parsedURI, error) {
 	}
 	return result, nil
 }
+
+// Query component of a DNS URI
+// https://tools.ietf.org/html/rfc4501#section-2
+//
+// dnsquery        = dnsqueryelement [";" dnsqueryelement]
+// dnsqueryelement = ( "CLASS=" dnsclassval ) / ( "TYPE=" dnstypeval )
+//
+// Parameter names and mnemonics are case-insensitive, and each parameter can only appear once.
+func parseDnsQuery(query string) (qclass uint16, qtype uint16, err error) {
+	qclass = dns.ClassINET
+	qtype = dns.TypeTXT
+	query = strings.TrimPrefix(query, "?")
+	if query == "" {
+		return qclass, qtype, nil
+	}
+	seen := make(map[string]bool)
+	for _, element := range strings.Split(query, ";") {
+		parts := strings.SplitN(element, "=", 2)
+		name := strings.ToUpper(parts[0])
+		if len(parts) != 2 || parts[1] == "" {
+			return 0, 0, fmt.Errorf("malformed parameter \"%s\" in DNS URI query (expected NAME=VALUE)", element)
+		}
+		if seen[name] {
+			return 0, 0, fmt.Errorf("repeated parameter \"%s\" in DNS URI query", parts[0])
+		}
+		seen[name] = true
+		switch name {
+		case "CLASS":
+			qclass, err = parseDnsQueryValue(parts[1], dns.StringToClass)
+			if err != nil {
+				return 0, 0, errors.Wrap(err, "invalid CLASS in DNS URI query")
+			}
+		case "TYPE":
+			qtype, err = parseDnsQueryValue(parts[1], dns.StringToType)
+			if err != nil {
+				return 0, 0, errors.Wrap(err, "invalid TYPE in DNS URI query")
+			}
+		default:
+			return 0, 0, fmt.Errorf("unsupported parameter \"%s\" in DNS URI query (only CLASS and TYPE are defined)", parts[0])
+		}
+	}
+	return qclass, qtype, nil
+}
+
+// Values can be mnemonics (IN, CH, TXT) or decimal numbers
+func parseDnsQueryValue(value string, mnemonics map[string]uint16) (uint16, error) {
+	if v, ok := mnemonics[strings.ToUpper(value)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown value \"%s\"", value)
+	}
+	return uint16(v), nil
+}
+
+// Query component of an ip: URI, in the same format as for DNS URIs
+//
+// ipquery = "FALLBACK=" prefixlength
+func parseIPQuery(query string) (fallback int, err error) {
+	query = strings.TrimPrefix(query, "?")
+	if query == "" {
+		return 0, nil
+	}
+	seen := make(map[string]bool)
+	for _, element := range strings.Split(query, ";") {
+		parts := strings.SplitN(element, "=", 2)
+		name := strings.ToUpper(parts[0])
+		if len(parts) != 2 || parts[1] == "" {
+			return 0, fmt.Errorf("malformed parameter \"%s\" in IP URI query (expected NAME=VALUE)", element)
+		}
+		if seen[name] {
+			return 0, fmt.Errorf("repeated parameter \"%s\" in IP URI query", parts[0])
+		}
+		seen[name] = true
+		switch name {
+		case "FALLBACK":
+			fallback, err = strconv.Atoi(strings.TrimPrefix(parts[1], "/"))
+			if err != nil {
+				return 0, fmt.Errorf("invalid FALLBACK in IP URI query: \"%s\" isn't a prefix length", parts[1])
+			}
+		default:
+			return 0, fmt.Errorf("unsupported parameter \"%s\" in IP URI query (only FALLBACK is defined)", parts[0])
+		}
+	}
+	return fallback, nil
+}
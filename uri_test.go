@@ -4,6 +4,8 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/miekg/dns"
 )
 
 type parseURITestPair struct {
@@ -37,3 +39,76 @@ func TestParseURI(t *testing.T) {
 		}
 	}
 }
+
+type parseDnsQueryTestPair struct {
+	Input string
+	Class uint16
+	Type  uint16
+	Err   error
+}
+
+func TestParseDnsQuery(t *testing.T) {
+	for _, testPair := range []parseDnsQueryTestPair{
+		{"", dns.ClassINET, dns.TypeTXT, nil},
+		{"?", dns.ClassINET, dns.TypeTXT, nil},
+		{"?CLASS=IN;TYPE=TXT", dns.ClassINET, dns.TypeTXT, nil},
+		{"?clAsS=ch", dns.ClassCHAOS, dns.TypeTXT, nil},
+		{"?TYPE=spf", dns.ClassINET, dns.TypeSPF, nil},
+		{"?type=URI;class=HS", dns.ClassHESIOD, dns.TypeURI, nil},
+		{"?CLASS=3;TYPE=16", dns.ClassCHAOS, dns.TypeTXT, nil},
+		{"?CLASS=IN;CLASS=CH", 0, 0, errors.New("Repeated parameter")},
+		{"?CLASS=NOSUCHCLASS", 0, 0, errors.New("Unknown class")},
+		{"?TYPE=65536", 0, 0, errors.New("Type out of range")},
+		{"?TYPE=", 0, 0, errors.New("Empty value")},
+		{"?TYPE", 0, 0, errors.New("Missing value")},
+		{"?TYPE=TXT;", 0, 0, errors.New("Empty element")},
+		{"?TTL=60", 0, 0, errors.New("Unknown parameter")},
+	} {
+		qclass, qtype, err := parseDnsQuery(testPair.Input)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if qclass != testPair.Class || qtype != testPair.Type {
+			t.Error("Expected", testPair.Class, testPair.Type, "but got", qclass, qtype, "for", testPair)
+		}
+	}
+}
+
+type parseIPQueryTestPair struct {
+	Input    string
+	Fallback int
+	Err      error
+}
+
+func TestParseIPQuery(t *testing.T) {
+	for _, testPair := range []parseIPQueryTestPair{
+		{"", 0, nil},
+		{"?", 0, nil},
+		{"?FALLBACK=24", 24, nil},
+		{"?fallback=/64", 64, nil},
+		{"?FALLBACK=24;FALLBACK=16", 0, errors.New("Repeated parameter")},
+		{"?FALLBACK=class-c", 0, errors.New("Not a number")},
+		{"?FALLBACK", 0, errors.New("Missing value")},
+		{"?TYPE=TXT", 0, errors.New("Unknown parameter")},
+	} {
+		fallback, err := parseIPQuery(testPair.Input)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if fallback != testPair.Fallback {
+			t.Error("Expected", testPair.Fallback, "but got", fallback, "for", testPair)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+// Time-bounded values: key@from=TIME@until=TIME=value
+//
+// A value is valid from its "from" time (inclusive) until its "until" time (exclusive), and either bound can be left
+// out.  If several windows for a key are valid at once, the one that started most recently wins, so a scheduled value
+// overrides a permanent one while its window lasts.  A window with only an until time starts as early as a permanent
+// value, but still wins over it.
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type validity struct {
+	from  time.Time // Zero means no lower bound
+	until time.Time // Zero means no upper bound
+}
+
+func (v validity) bounded() bool {
+	return !v.from.IsZero() || !v.until.IsZero()
+}
+
+func (v validity) contains(t time.Time) bool {
+	return (v.from.IsZero() || !t.Before(v.from)) && (v.until.IsZero() || t.Before(v.until))
+}
+
+func (v validity) expired(t time.Time) bool {
+	return !v.until.IsZero() && !t.Before(v.until)
+}
+
+func (v validity) equal(other validity) bool {
+	return v.from.Equal(other.from) && v.until.Equal(other.until)
+}
+
+func (v validity) overlaps(other validity) bool {
+	return (v.until.IsZero() || other.from.IsZero() || other.from.Before(v.until)) &&
+		(other.until.IsZero() || v.from.IsZero() || v.from.Before(other.until))
+}
+
+func (v validity) String() string {
+	var parts []string
+	if !v.from.IsZero() {
+		parts = append(parts, "from "+v.from.Format(time.RFC3339))
+	}
+	if !v.until.IsZero() {
+		parts = append(parts, "until "+v.until.Format(time.RFC3339))
+	}
+	if len(parts) == 0 {
+		return "always"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Accepts RFC 3339 times, with or without seconds, or just dates (meaning midnight UTC)
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid time \"%s\" (expected e.g. 2026-11-01, 2026-11-01T09:30Z or 2026-11-01T09:30:00+10:00)", value)
+}
+
+// Checks for a qualifier (@from= or @until=) at the start of s
+func isQualifier(s string) bool {
+	s = strings.ToLower(s)
+	return strings.HasPrefix(s, "@from=") || strings.HasPrefix(s, "@until=")
+}
+
+// Parses one or more qualifiers at the start of s (which starts with @), and returns the rest after the = that ends
+// the key.  ok is false if there's no such =.
+func parseQualifiers(s string) (window validity, rest string, ok bool, err error) {
+	var names, values []string
+	for strings.HasPrefix(s, "@") {
+		s = s[1:]
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			return validity{}, "", false, nil
+		}
+		names = append(names, strings.ToLower(strings.TrimSpace(s[:i])))
+		s = s[i+1:]
+		end := strings.IndexAny(s, "@=")
+		if end < 0 {
+			return validity{}, "", false, nil
+		}
+		values = append(values, strings.TrimSpace(s[:end]))
+		s = s[end:]
+	}
+	// The loop only ends at the = before the value
+	rest = s[1:]
+
+	for i, name := range names {
+		t, err := parseTime(values[i])
+		switch {
+		case name != "from" && name != "until":
+			return validity{}, rest, true, errors.Errorf("unknown qualifier \"@%s\"", name)
+		case err != nil:
+			return validity{}, rest, true, err
+		case name == "from" && !window.from.IsZero(), name == "until" && !window.until.IsZero():
+			return validity{}, rest, true, errors.Errorf("repeated qualifier \"@%s\"", name)
+		case name == "from":
+			window.from = t
+		default:
+			window.until = t
+		}
+	}
+	if !window.from.IsZero() && !window.until.IsZero() && !window.from.Before(window.until) {
+		return validity{}, rest, true, errors.Errorf("empty validity window (%s)", window)
+	}
+	return window, rest, true, nil
+}
+
+type qualifiedValue struct {
+	value   string
+	variant variant
+	window  validity
+}
+
+// Keeps the values that are valid at time now, with the most recently started window taking precedence.  Warns about
+// overlapping windows, and about keys whose windows have all expired.
+func selectValidValues(options *options, key string, values []qualifiedValue, now time.Time) []string {
+	for i, a := range values {
+		for _, b := range values[i+1:] {
+			if a.window.bounded() && b.window.bounded() && !a.window.equal(b.window) && a.window.overlaps(b.window) {
+				warnf(options, "validity windows for key %q overlap (%s; %s), so the one that started later takes precedence", key, a.window, b.window)
+			}
+		}
+	}
+
+	var active []qualifiedValue
+	allExpired := len(values) > 0
+	for _, value := range values {
+		if !value.window.expired(now) {
+			allExpired = false
+		}
+		if value.window.contains(now) {
+			active = append(active, value)
+		} else if value.window.bounded() {
+			tracef(options, traceSummary, "value %q for key %q isn't valid at %s (%s)", redactValue(options, key, value.value), key, now.Format(time.RFC3339), value.window)
+		}
+	}
+	if allExpired {
+		warnf(options, "every validity window for key %q has expired", key)
+	}
+
+	var latest time.Time
+	latestBounded := false
+	for _, value := range active {
+		if value.window.from.After(latest) || value.window.from.Equal(latest) && value.window.bounded() {
+			latest, latestBounded = value.window.from, value.window.bounded()
+		}
+	}
+	var results []string
+	for _, value := range active {
+		if value.window.from.Equal(latest) && value.window.bounded() == latestBounded {
+			results = append(results, value.value)
+		}
+	}
+	return results
+}
+
+// The time to evaluate time-bounded values at (--at, or now)
+func lookupTime(options *options) time.Time {
+	if !options.at.IsZero() {
+		return options.at
+	}
+	return time.Now()
+}
+
+// kingpin flag value for --at
+type timeValue struct {
+	t *time.Time
+}
+
+func (v *timeValue) Set(value string) error {
+	t, err := parseTime(value)
+	if err != nil {
+		return err
+	}
+	*v.t = t
+	return nil
+}
+
+func (v *timeValue) String() string {
+	if v.t.IsZero() {
+		return ""
+	}
+	return v.t.Format(time.RFC3339)
+}
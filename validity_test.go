@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	result, err := parseTime(value)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	return result
+}
+
+type parseTimeTestPair struct {
+	Input  string
+	Result string
+	Err    error
+}
+
+func TestParseTime(t *testing.T) {
+	for _, testPair := range []parseTimeTestPair{
+		{"2026-11-01", "2026-11-01T00:00:00Z", nil},
+		{"2026-11-01T09:30Z", "2026-11-01T09:30:00Z", nil},
+		{"2026-11-01T09:30:15Z", "2026-11-01T09:30:15Z", nil},
+		{"2026-11-01T09:30+10:00", "2026-10-31T23:30:00Z", nil},
+		{"2026-11-01T09:30:00-05:00", "2026-11-01T14:30:00Z", nil},
+		{"2026-11-01 09:30", "", errors.New("No T")},
+		{"tomorrow", "", errors.New("Not a time")},
+		{"", "", errors.New("Empty")},
+	} {
+		result, err := parseTime(testPair.Input)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if err == nil && result.UTC().Format(time.RFC3339) != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+type parseRecordTestPair struct {
+	Record   string
+	IsRecord bool
+	Key      string
+	Value    string
+	From     string
+	Until    string
+	Err      error
+}
+
+func TestParseRecordQualifiers(t *testing.T) {
+	for _, testPair := range []parseRecordTestPair{
+		{"key=value", true, "key", "value", "", "", nil},
+		{"key@from=2026-11-01=value", true, "key", "value", "2026-11-01", "", nil},
+		{"key@until=2026-11-01T09:30Z=value=with=equals", true, "key", "value=with=equals", "", "2026-11-01T09:30Z", nil},
+		{"key@from=2026-11-01@until=2026-11-02=value", true, "key", "value", "2026-11-01", "2026-11-02", nil},
+		{"key@UNTIL=2026-11-02@From=2026-11-01=value", true, "key", "value", "2026-11-01", "2026-11-02", nil},
+		{" key @from= 2026-11-01 =value", true, "key", "value", "2026-11-01", "", nil},
+		{"admin@example.com=someone", true, "admin@example.com", "someone", "", "", nil},
+		{"key`@from=2026-11-01=value", true, "key@from", "2026-11-01=value", "", "", nil},
+		{"key=value@from=2026-11-01", true, "key", "value@from=2026-11-01", "", "", nil},
+		{"key@from=2026-11-01", false, "", "", "", "", nil},
+		{"key@from=2026-11-01@until", false, "", "", "", "", nil},
+		{"key@from=soon=value", true, "key", "value", "", "", errors.New("Bad time")},
+		{"key@from=2026-11-01@at=2026-11-02=value", true, "key", "value", "", "", errors.New("Unknown qualifier")},
+		{"key@from=2026-11-01@from=2026-11-02=value", true, "key", "value", "", "", errors.New("Repeated qualifier")},
+		{"key@from=2026-11-02@until=2026-11-01=value", true, "key", "value", "", "", errors.New("Empty window")},
+	} {
+		isRecord, parsed, err := parseRecord(testPair.Record)
+		key, value, window := parsed.key, parsed.value, parsed.window
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if isRecord != testPair.IsRecord || key != testPair.Key || value != testPair.Value {
+			t.Error("Expected", testPair.IsRecord, testPair.Key, testPair.Value, "but got", isRecord, key, value, "for", testPair)
+		}
+
+		var expected validity
+		if testPair.From != "" {
+			expected.from = mustParseTime(t, testPair.From)
+		}
+		if testPair.Until != "" {
+			expected.until = mustParseTime(t, testPair.Until)
+		}
+		if !window.equal(expected) {
+			t.Error("Expected window", expected, "but got", window, "for", testPair)
+		}
+	}
+}
+
+var scheduledTxtRecords = []string{
+	"cert=old",
+	"cert@from=2026-11-01T00:00Z=new",
+	"maintenance@from=2026-11-01T02:00Z@until=2026-11-01T04:00Z=true",
+	"maintenance=false",
+	"promo@until=2026-01-01=winter sale",
+	"promo@until=2026-01-01=free shipping",
+	"mirror@from=2026-11-01=a",
+	"mirror@from=2026-11-01=b",
+	"broken@from=someday=x",
+	"overlap@from=2026-11-01@until=2026-11-10=first",
+	"overlap@from=2026-11-05@until=2026-11-20=second",
+	"deadline=default",
+	"deadline@until=2026-12-01=sale",
+}
+
+type validLookUpTestPair struct {
+	At      string
+	Key     string
+	Result  []string
+	Warning string
+}
+
+func TestLookUpTimeBoundedValues(t *testing.T) {
+	for _, testPair := range []validLookUpTestPair{
+		{"2026-10-31T23:59:59Z", "cert", []string{"old"}, ""},
+		{"2026-11-01T00:00:00Z", "cert", []string{"new"}, ""},
+		{"2026-11-01T01:59Z", "maintenance", []string{"false"}, ""},
+		{"2026-11-01T02:00Z", "maintenance", []string{"true"}, ""},
+		{"2026-11-01T04:00Z", "maintenance", []string{"false"}, ""},
+		{"2025-12-31", "promo", []string{"free shipping", "winter sale"}, ""},
+		{"2026-01-01", "promo", nil, "every validity window for key \"promo\" has expired"},
+		{"2026-10-31", "mirror", nil, ""},
+		{"2026-11-02", "mirror", []string{"a", "b"}, ""},
+		{"2026-11-02", "broken", nil, "ignoring \"broken@from=someday=x\": invalid time \"someday\""},
+		{"2026-11-02", "overlap", []string{"first"}, "validity windows for key \"overlap\" overlap"},
+		{"2026-11-06", "overlap", []string{"second"}, "validity windows for key \"overlap\" overlap"},
+		{"2026-11-15", "overlap", []string{"second"}, "validity windows for key \"overlap\" overlap"},
+		{"2026-11-30", "deadline", []string{"sale"}, ""},
+		{"2026-12-01", "deadline", []string{"default"}, ""},
+	} {
+		var warnings bytes.Buffer
+		options := makeDefaultOptions()
+		options.valueType = "list"
+		options.at = mustParseTime(t, testPair.At)
+		options.traceSink = &warnings
+		result, err := lookUpValues(options, scheduledTxtRecords, testPair.Key, nil)
+		if err != nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+
+		if testPair.Warning == "" && warnings.Len() > 0 {
+			t.Error("Unexpected warning", warnings.String(), "for", testPair)
+		}
+		if testPair.Warning != "" && !strings.Contains(warnings.String(), "Warning: "+testPair.Warning) {
+			t.Error("Expected warning", testPair.Warning, "but got", warnings.String(), "for", testPair)
+		}
+	}
+}
+
+func TestTimeValue(t *testing.T) {
+	var at time.Time
+	value := &timeValue{&at}
+	if value.String() != "" {
+		t.Error("Expected empty string for unset time but got", value.String())
+	}
+	if err := value.Set("2026-11-01T09:30Z"); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !at.Equal(time.Date(2026, 11, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Error("Expected 2026-11-01T09:30Z but got", at)
+	}
+	if err := value.Set("whenever"); err == nil {
+		t.Error("Expected error not caught for invalid time")
+	}
+}
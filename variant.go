@@ -0,0 +1,168 @@
+package main
+
+// Variants of keys: key[env=prod,region=eu]=value
+//
+// A variant only applies if --select has all of its conditions.  The most specific applicable variant (the one with
+// the most conditions) wins, and the plain key is the fallback.  Two different variants that are equally specific are
+// an error, because there's no way to tell which was meant.
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type condition struct {
+	name  string
+	value string
+}
+
+type variant []condition
+
+func parseVariant(s string) (variant, error) {
+	var result variant
+	names := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		i := strings.IndexByte(part, '=')
+		if i < 0 {
+			return nil, errors.Errorf("invalid condition \"%s\" in variant (expected name=value)", strings.TrimSpace(part))
+		}
+		name, value := strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		if name == "" || value == "" {
+			return nil, errors.Errorf("invalid condition \"%s\" in variant (expected name=value)", strings.TrimSpace(part))
+		}
+		if names[strings.ToLower(name)] {
+			return nil, errors.Errorf("repeated condition \"%s\" in variant", name)
+		}
+		names[strings.ToLower(name)] = true
+		result = append(result, condition{name, value})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result, nil
+}
+
+func (v variant) String() string {
+	if len(v) == 0 {
+		return "(no variant)"
+	}
+	var parts []string
+	for _, c := range v {
+		parts = append(parts, c.name+"="+c.value)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// Identifies variants that only differ in case or order
+func (v variant) id(options *options) string {
+	var parts []string
+	for _, c := range v {
+		parts = append(parts, normalizeKey(options, c.name)+"="+normalizeKey(options, c.value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// Checks that every condition of the variant is in the selectors
+func (v variant) matches(options *options, selectors variant) bool {
+	for _, c := range v {
+		found := false
+		for _, s := range selectors {
+			if normalizeKey(options, c.name) == normalizeKey(options, s.name) && normalizeKey(options, c.value) == normalizeKey(options, s.value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Chooses the most specific variant that has valid values at time now
+func selectVariant(options *options, key string, values []qualifiedValue, now time.Time) ([]string, error) {
+	var ids []string
+	groups := make(map[string][]qualifiedValue)
+	for _, value := range values {
+		if !value.variant.matches(options, options.selectors) {
+			tracef(options, traceSummary, "variant %s of key %q doesn't match --select", value.variant, key)
+			continue
+		}
+		id := value.variant.id(options)
+		if _, ok := groups[id]; !ok {
+			ids = append(ids, id)
+		}
+		groups[id] = append(groups[id], value)
+	}
+
+	type candidate struct {
+		variant variant
+		values  []string
+	}
+	var candidates []candidate
+	specificity := 0
+	for _, id := range ids {
+		group := groups[id]
+		if results := selectValidValues(options, key, group, now); len(results) > 0 {
+			candidates = append(candidates, candidate{group[0].variant, results})
+			if len(group[0].variant) > specificity {
+				specificity = len(group[0].variant)
+			}
+		}
+	}
+
+	var winners []candidate
+	var names []string
+	for _, c := range candidates {
+		if len(c.variant) == specificity {
+			winners = append(winners, c)
+			names = append(names, c.variant.String())
+		}
+	}
+	if len(winners) == 0 {
+		return nil, nil
+	}
+	if len(winners) > 1 {
+		return nil, errors.Errorf("key %s is ambiguous: variants %s all match --select", key, strings.Join(names, ", "))
+	}
+	if len(ids) > 1 || specificity > 0 {
+		tracef(options, traceSummary, "chose variant %s of key %q", winners[0].variant, key)
+	}
+	return winners[0].values, nil
+}
+
+// kingpin flag value for --select, which can be repeated
+type selectorsValue struct {
+	selectors *variant
+}
+
+func (v *selectorsValue) Set(value string) error {
+	selectors, err := parseVariant(value)
+	if err != nil {
+		return err
+	}
+	for _, c := range selectors {
+		for _, existing := range *v.selectors {
+			if strings.ToLower(c.name) == strings.ToLower(existing.name) {
+				return errors.Errorf("repeated selector \"%s\"", c.name)
+			}
+		}
+	}
+	combined := append(*v.selectors, selectors...)
+	sort.Slice(combined, func(i, j int) bool { return combined[i].name < combined[j].name })
+	*v.selectors = combined
+	return nil
+}
+
+func (v *selectorsValue) String() string {
+	if len(*v.selectors) == 0 {
+		return ""
+	}
+	return v.selectors.String()
+}
+
+func (v *selectorsValue) IsCumulative() bool {
+	return true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func mustParseVariant(t *testing.T, s string) variant {
+	if s == "" {
+		return nil
+	}
+	result, err := parseVariant(s)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	return result
+}
+
+type parseRecordVariantTestPair struct {
+	Record   string
+	IsRecord bool
+	Key      string
+	Value    string
+	Variant  string
+	Err      error
+}
+
+func TestParseRecordVariants(t *testing.T) {
+	for _, testPair := range []parseRecordVariantTestPair{
+		{"timeout=30", true, "timeout", "30", "", nil},
+		{"timeout[env=prod]=60", true, "timeout", "60", "env=prod", nil},
+		{"timeout [ env = prod , region=eu ] = 60", true, "timeout", " 60", "env=prod,region=eu", nil},
+		{"timeout[region=eu,env=prod]=60", true, "timeout", "60", "env=prod,region=eu", nil},
+		{"timeout[env=prod]@from=2026-11-01=90", true, "timeout", "90", "env=prod", nil},
+		{"list[0]=a", true, "list[0]", "a", "", nil},
+		{"a[env=prod]b=c", true, "a[env=prod]b", "c", "", nil},
+		{"key`[env=prod]=x", true, "key[env", "prod]=x", "", nil},
+		{"unbalanced[=x", true, "unbalanced[", "x", "", nil},
+		{"[env=prod]=x", true, "", "x", "env=prod", nil},
+		{"key=value[env=prod]", true, "key", "value[env=prod]", "", nil},
+		{"key[env=prod]", true, "key[env", "prod]", "", nil},
+		{"key[env=prod,region]=x", true, "key", "x", "", errors.New("Bad condition")},
+		{"key[=prod]=x", true, "key", "x", "", errors.New("Empty name")},
+		{"key[env=]=x", true, "key", "x", "", errors.New("Empty value")},
+		{"key[env=a,ENV=b]=x", true, "key", "x", "", errors.New("Repeated condition")},
+	} {
+		isRecord, parsed, err := parseRecord(testPair.Record)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		if isRecord != testPair.IsRecord || parsed.key != testPair.Key || parsed.value != testPair.Value {
+			t.Error("Expected", testPair.IsRecord, testPair.Key, testPair.Value, "but got", isRecord, parsed.key, parsed.value, "for", testPair)
+		}
+
+		if err == nil && !reflect.DeepEqual(parsed.variant, mustParseVariant(t, testPair.Variant)) {
+			t.Error("Expected variant", testPair.Variant, "but got", parsed.variant, "for", testPair)
+		}
+	}
+}
+
+var variantTxtRecords = []string{
+	"timeout=30",
+	"timeout[env=prod]=60",
+	"timeout[env=prod,region=eu]=90",
+	"timeout[env=staging]=10",
+	"replicas[env=prod]=3",
+	"replicas[region=eu]=2",
+	"hosts=a",
+	"hosts=b",
+	"hosts[env=prod]=c",
+	"hosts[env=prod]=d",
+	"only[env=prod]=x",
+	"rollout[env=prod]@until=2026-01-01=old",
+	"rollout=default",
+}
+
+type variantLookUpTestPair struct {
+	Select string
+	Key    string
+	Result []string
+	Err    error
+}
+
+func TestLookUpVariants(t *testing.T) {
+	for _, testPair := range []variantLookUpTestPair{
+		{"", "timeout", []string{"30"}, nil},
+		{"env=prod", "timeout", []string{"60"}, nil},
+		{"ENV=Prod", "timeout", []string{"60"}, nil},
+		{"env=prod,region=eu", "timeout", []string{"90"}, nil},
+		{"env=prod,region=us", "timeout", []string{"60"}, nil},
+		{"env=dev,region=eu", "timeout", []string{"30"}, nil},
+		{"env=staging", "timeout", []string{"10"}, nil},
+		{"env=prod", "replicas", []string{"3"}, nil},
+		{"env=prod,region=eu", "replicas", nil, errors.New("Ambiguous")},
+		{"", "replicas", nil, nil},
+		{"", "hosts", []string{"a", "b"}, nil},
+		{"env=prod", "hosts", []string{"c", "d"}, nil},
+		{"env=dev", "only", nil, nil},
+		{"env=prod", "rollout", []string{"default"}, nil},
+	} {
+		options := makeDefaultOptions()
+		options.valueType = "list"
+		options.selectors = mustParseVariant(t, testPair.Select)
+		options.at = mustParseTime(t, "2026-10-15")
+		result, err := lookUpValues(options, variantTxtRecords, testPair.Key, nil)
+
+		if err != nil && testPair.Err == nil {
+			t.Error("Unexpected error", err.Error(), "for", testPair)
+		}
+
+		if err == nil && testPair.Err != nil {
+			t.Error("Expected error", testPair.Err.Error(), "not caught for", testPair)
+		}
+
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, testPair.Result) {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
+
+func TestTraceChosenVariant(t *testing.T) {
+	var trace bytes.Buffer
+	options := makeDefaultOptions()
+	options.selectors = mustParseVariant(t, "env=prod,region=eu")
+	options.verbosity = traceSummary
+	options.traceSink = &trace
+	if _, err := lookUpValues(options, variantTxtRecords, "timeout", nil); err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if !strings.Contains(trace.String(), `chose variant [env=prod,region=eu] of key "timeout"`) {
+		t.Error("Expected chosen variant in trace but got", trace.String())
+	}
+}
+
+func TestSelectorsValue(t *testing.T) {
+	var selectors variant
+	value := &selectorsValue{&selectors}
+	for _, s := range []string{"region=eu", "env=prod,tier=web"} {
+		if err := value.Set(s); err != nil {
+			t.Fatal("Error", err.Error())
+		}
+	}
+	if value.String() != "[env=prod,region=eu,tier=web]" {
+		t.Error("Expected [env=prod,region=eu,tier=web] but got", value.String())
+	}
+	if err := value.Set("Region=us"); err == nil {
+		t.Error("Expected error not caught for repeated selector")
+	}
+	if err := value.Set("env"); err == nil {
+		t.Error("Expected error not caught for invalid selector")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+// sdget wait: polls nameservers until a change has propagated to all of them
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+type waitState int
+
+const (
+	waitMatched     waitState = iota // Server has the expected data
+	waitMismatched                   // Server answered, but with something else (including NXDOMAIN)
+	waitUnreachable                  // No usable answer from the server
+	waitTimedOut                     // Servers still had other data when the deadline passed
+)
+
+type waitOptions struct {
+	servers         []string // Empty means the authoritative servers for the domain
+	timeout         time.Duration
+	initialInterval time.Duration
+	maxInterval     time.Duration
+}
+
+func makeDefaultWaitOptions() *waitOptions {
+	return &waitOptions{
+		timeout:         5 * time.Minute,
+		initialInterval: time.Second,
+		maxInterval:     30 * time.Second,
+	}
+}
+
+// Checks one server, returning its state and a short description for progress output
+type waitCondition func(provider *dnsProvider) (waitState, string)
+
+func waitForValues(options *options, key string, expected []string) waitCondition {
+	expected = append([]string{}, expected...)
+	sort.Strings(expected)
+	return func(provider *dnsProvider) (waitState, string) {
+		records, err := provider.getKeyTxtRecordsFrom(key, provider.nameservers)
+		if err != nil {
+			return errorState(err), err.Error()
+		}
+		values, err := lookUpValues(options, records, key, nil)
+		if err != nil {
+			return waitMismatched, err.Error()
+		}
+		sort.Strings(values)
+		// No values (waiting for a key to disappear) is nil from lookUpValues, but might be an empty slice here
+		if len(values) == 0 && len(expected) == 0 || reflect.DeepEqual(values, expected) {
+			return waitMatched, fmt.Sprintf("%q", values)
+		}
+		return waitMismatched, fmt.Sprintf("got %q", values)
+	}
+}
+
+func waitForSerial(options *options, zone string, minimum uint32) waitCondition {
+	return func(provider *dnsProvider) (waitState, string) {
+		serial, err := querySerial(options, provider, zone)
+		if err != nil {
+			return errorState(err), err.Error()
+		}
+		if serialAtLeast(serial, minimum) {
+			return waitMatched, fmt.Sprintf("serial %d", serial)
+		}
+		return waitMismatched, fmt.Sprintf("serial %d", serial)
+	}
+}
+
+// Network problems mean the server might still be fine; anything else is a real (but maybe outdated) answer
+func errorState(err error) waitState {
+	if _, ok := errors.Cause(err).(net.Error); ok {
+		return waitUnreachable
+	}
+	return waitMismatched
+}
+
+func querySerial(options *options, provider *dnsProvider, zone string) (uint32, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(zone, dns.TypeSOA)
+	query.RecursionDesired = provider.recurse
+	nameserver := provider.nameservers[0]
+	response, _, err := exchange(options, query, nameserver, "tcp")
+	if err != nil {
+		return 0, errors.Wrap(err, "error executing DNS query")
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return 0, errors.Errorf("error from remote DNS server: %s", dns.RcodeToString[response.Rcode])
+	}
+	if !provider.recurse && !response.Authoritative {
+		return 0, errors.Errorf("answer from %s is not authoritative (AA bit not set)", nameserver)
+	}
+	for _, rr := range response.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, errors.Errorf("no SOA record for %s", zone)
+}
+
+// Serial number arithmetic (https://tools.ietf.org/html/rfc1982), so that wrapped serials still compare properly
+func serialAtLeast(serial uint32, minimum uint32) bool {
+	return serial == minimum || int32(serial-minimum) > 0
+}
+
+// The servers to poll, and whether they should be asked to recurse
+func waitServers(options *options, waitOptions *waitOptions, provider *dnsProvider) ([]string, bool, error) {
+	if len(waitOptions.servers) > 0 {
+		var servers []string
+		for _, server := range waitOptions.servers {
+			server, err := addNameserverPort(options, server)
+			if err != nil {
+				return nil, false, err
+			}
+			servers = append(servers, server)
+		}
+		return servers, true, nil
+	}
+	_, servers, err := findAuthoritativeServers(options, provider.nameservers[0], provider.domain)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "error finding authoritative servers to poll")
+	}
+	return servers, false, nil
+}
+
+// Polls every server with exponential backoff until they all match or the deadline passes
+// The returned state is waitMatched if all servers matched.  Otherwise it's waitUnreachable if any server never gave a
+// usable answer, or waitTimedOut if they all answered but some still had other data (waitMismatched with a zero
+// timeout, since nothing was waited for).
+func runWait(options *options, waitOptions *waitOptions, provider *dnsProvider, servers []string, recurse bool, condition waitCondition, progress io.Writer) waitState {
+	deadline := time.Now().Add(waitOptions.timeout)
+	interval := waitOptions.initialInterval
+	states := make(map[string]waitState)
+	pending := append([]string{}, servers...)
+	for {
+		var stillPending []string
+		for _, server := range pending {
+			serverProvider := *provider
+			serverProvider.nameservers = []string{server}
+			serverProvider.recurse = recurse
+			state, detail := condition(&serverProvider)
+			if state == waitMatched {
+				fmt.Fprintf(progress, "%s: ok (%s)\n", server, detail)
+			} else {
+				fmt.Fprintf(progress, "%s: waiting (%s)\n", server, detail)
+				stillPending = append(stillPending, server)
+			}
+			states[server] = state
+		}
+		pending = stillPending
+		if len(pending) == 0 {
+			return waitMatched
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		if interval > remaining {
+			interval = remaining
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > waitOptions.maxInterval {
+			interval = waitOptions.maxInterval
+		}
+	}
+
+	result := waitTimedOut
+	if waitOptions.timeout <= 0 {
+		result = waitMismatched
+	}
+	for _, server := range pending {
+		if states[server] == waitUnreachable {
+			result = waitUnreachable
+		}
+	}
+	fmt.Fprintf(progress, "gave up after %v: %d of %d servers not updated (%s)\n", waitOptions.timeout, len(pending), len(servers), strings.Join(pending, ", "))
+	return result
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const waitOldZone = `
+example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 41 3600 600 86400 300
+config.example.com. 300 IN TXT "version=1"
+`
+
+const waitNewZone = `
+example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 42 3600 600 86400 300
+config.example.com. 300 IN TXT "version=2"
+`
+
+func makeTestWaitOptions(servers ...string) *waitOptions {
+	return &waitOptions{
+		servers:         servers,
+		timeout:         100 * time.Millisecond,
+		initialInterval: 10 * time.Millisecond,
+		maxInterval:     20 * time.Millisecond,
+	}
+}
+
+type runWaitTestPair struct {
+	Zones     []string
+	Condition waitCondition
+	Result    waitState
+}
+
+func TestRunWait(t *testing.T) {
+	options := makeDefaultOptions()
+	listOptions := makeDefaultOptions()
+	listOptions.valueType = "list"
+	for _, testPair := range []runWaitTestPair{
+		{[]string{waitNewZone}, waitForValues(options, "version", []string{"2"}), waitMatched},
+		{[]string{waitNewZone, waitNewZone}, waitForValues(options, "version", []string{"2"}), waitMatched},
+		{[]string{waitNewZone, waitOldZone}, waitForValues(options, "version", []string{"2"}), waitTimedOut},
+		{[]string{waitNewZone, ""}, waitForValues(options, "version", []string{"2"}), waitTimedOut},
+		{[]string{waitNewZone}, waitForValues(listOptions, "missing", []string{}), waitMatched},
+		{[]string{waitNewZone, waitOldZone}, waitForSerial(options, "example.com.", 41), waitMatched},
+		{[]string{waitNewZone, waitOldZone}, waitForSerial(options, "example.com.", 42), waitTimedOut},
+	} {
+		var servers []string
+		for _, zone := range testPair.Zones {
+			address, shutdown := startTestServer(t, zone)
+			defer shutdown()
+			servers = append(servers, address)
+		}
+		waitOptions := makeTestWaitOptions(servers...)
+		provider, err := makeDnsProvider(options, servers[0], "config.example.com", dns.ClassINET, dns.TypeTXT)
+		if err != nil {
+			t.Fatal("Error", err.Error())
+		}
+
+		var progress bytes.Buffer
+		result := runWait(options, waitOptions, provider, servers, true, testPair.Condition, &progress)
+		if result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair.Zones, "\n", progress.String())
+		}
+		if !strings.Contains(progress.String(), servers[0]+": ok") {
+			t.Error("Expected progress for", servers[0], "but got\n", progress.String())
+		}
+	}
+}
+
+func TestRunWaitOnce(t *testing.T) {
+	options := makeDefaultOptions()
+	var servers []string
+	for _, zone := range []string{waitNewZone, waitOldZone} {
+		address, shutdown := startTestServer(t, zone)
+		defer shutdown()
+		servers = append(servers, address)
+	}
+	provider, err := makeDnsProvider(options, servers[0], "config.example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+
+	// With no time to wait, a server with other data is a mismatch, not a timeout
+	waitOptions := makeTestWaitOptions(servers...)
+	waitOptions.timeout = 0
+	var progress bytes.Buffer
+	result := runWait(options, waitOptions, provider, servers, true, waitForValues(options, "version", []string{"2"}), &progress)
+	if result != waitMismatched {
+		t.Error("Expected", waitMismatched, "but got", result, "\n", progress.String())
+	}
+	if strings.Count(progress.String(), servers[1]+": waiting") != 1 {
+		t.Error("Expected one poll of", servers[1], "but got\n", progress.String())
+	}
+}
+
+func TestRunWaitUnreachable(t *testing.T) {
+	options := makeDefaultOptions()
+	address, shutdown := startTestServer(t, waitNewZone)
+	defer shutdown()
+	servers := []string{address, "127.0.0.1:1"}
+	provider, err := makeDnsProvider(options, address, "config.example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+
+	var progress bytes.Buffer
+	result := runWait(options, makeTestWaitOptions(servers...), provider, servers, true, waitForValues(options, "version", []string{"2"}), &progress)
+	if result != waitUnreachable {
+		t.Error("Expected", waitUnreachable, "but got", result, "\n", progress.String())
+	}
+	if !strings.Contains(progress.String(), "1 of 2 servers not updated (127.0.0.1:1)") {
+		t.Error("Expected summary but got\n", progress.String())
+	}
+}
+
+func TestWaitServersAuthoritative(t *testing.T) {
+	options, shutdown := startDoctorTestServer(t)
+	defer shutdown()
+	provider, err := makeDnsProvider(options, "", "config.example.com", dns.ClassINET, dns.TypeTXT)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+
+	servers, recurse, err := waitServers(options, makeDefaultWaitOptions(), provider)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if len(servers) != 1 || !strings.HasPrefix(servers[0], "127.0.0.1:") || recurse {
+		t.Error("Expected one non-recursive authoritative server but got", servers, recurse)
+	}
+
+	servers, recurse, err = waitServers(options, makeTestWaitOptions("192.0.2.1", "192.0.2.2:1053"), provider)
+	if err != nil {
+		t.Fatal("Error", err.Error())
+	}
+	if len(servers) != 2 || servers[0] != "192.0.2.1:53" || servers[1] != "192.0.2.2:1053" || !recurse {
+		t.Error("Expected the given recursive servers but got", servers, recurse)
+	}
+}
+
+type serialAtLeastTestPair struct {
+	Serial  uint32
+	Minimum uint32
+	Result  bool
+}
+
+func TestSerialAtLeast(t *testing.T) {
+	for _, testPair := range []serialAtLeastTestPair{
+		{1, 1, true},
+		{2, 1, true},
+		{1, 2, false},
+		{2026101501, 2026101500, true},
+		{0, 4294967295, true},
+		{4294967295, 0, false},
+		{5, 4294967290, true},
+	} {
+		if result := serialAtLeast(testPair.Serial, testPair.Minimum); result != testPair.Result {
+			t.Error("Expected", testPair.Result, "but got", result, "for", testPair)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+// Finding the authoritative nameservers for a domain, using a recursive resolver
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Authoritative servers are assumed to listen on the standard port (overridden in tests)
+var authoritativePort = "53"
+
+// Walks up from domain until a SOA record is found, and returns the owner name (i.e., the zone apex)
+// Negative answers (NXDOMAIN or NODATA) carry the zone's SOA in the authority section, so usually only one query is needed.
+func findZoneCut(options *options, resolver string, domain string) (string, error) {
+	name := dns.Fqdn(domain)
+	for {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeSOA)
+		query.RecursionDesired = true
+		response, _, err := exchange(options, query, resolver, "tcp")
+		if err != nil {
+			return "", errors.Wrapf(err, "error looking up SOA for %s", name)
+		}
+		if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+			return "", errors.Errorf("error from remote DNS server looking up SOA for %s: %s", name, dns.RcodeToString[response.Rcode])
+		}
+		for _, rr := range append(response.Answer, response.Ns...) {
+			if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+				tracef(options, traceSummary, "zone cut for %s is %s", domain, soa.Hdr.Name)
+				return soa.Hdr.Name, nil
+			}
+		}
+		if name == "." {
+			return "", errors.Errorf("no SOA record found for %s or any parent", domain)
+		}
+		offset, _ := dns.NextLabel(name, 0)
+		name = name[offset:]
+		if name == "" {
+			name = "."
+		}
+	}
+}
+
+// Returns the zone apex and the addresses (host:port) of the nameservers listed in the zone's NS records
+func findAuthoritativeServers(options *options, resolver string, domain string) (string, []string, error) {
+	zone, err := findZoneCut(options, resolver, domain)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hosts, err := queryNames(options, resolver, zone, dns.TypeNS)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error looking up nameservers for zone %s", zone)
+	}
+	if len(hosts) == 0 {
+		return "", nil, errors.Errorf("no NS records for zone %s", zone)
+	}
+
+	var servers []string
+	for _, host := range hosts {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			addresses, err := queryNames(options, resolver, host, qtype)
+			if err != nil {
+				return "", nil, errors.Wrapf(err, "error looking up address of nameserver %s", host)
+			}
+			for _, address := range addresses {
+				servers = append(servers, net.JoinHostPort(address, authoritativePort))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return "", nil, errors.Errorf("no addresses for nameservers of zone %s (%s)", zone, strings.Join(hosts, ", "))
+	}
+	sort.Strings(servers)
+	tracef(options, traceSummary, "authoritative servers for %s: %s", zone, strings.Join(servers, ", "))
+	return zone, servers, nil
+}
+
+// Does a recursive lookup of NS, A or AAAA records and returns the data as strings
+func queryNames(options *options, resolver string, name string, qtype uint16) ([]string, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(name, qtype)
+	query.RecursionDesired = true
+	response, _, err := exchange(options, query, resolver, "tcp")
+	if err != nil {
+		return nil, err
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return nil, errors.Errorf("error from remote DNS server: %s", dns.RcodeToString[response.Rcode])
+	}
+	var results []string
+	for _, rr := range response.Answer {
+		switch rr := rr.(type) {
+		case *dns.NS:
+			results = append(results, rr.Ns)
+		case *dns.A:
+			results = append(results, rr.A.String())
+		case *dns.AAAA:
+			results = append(results, rr.AAAA.String())
+		}
+	}
+	return results, nil
+}